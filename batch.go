@@ -0,0 +1,105 @@
+package blinktree
+
+import "fmt"
+
+/*
+ *  A batch is applied under the tree lock held exclusively, the same
+ *  lock a commit takes, instead of the atomic page locks of the C
+ *  implementation. Updates outside the batch are committed first; when
+ *  an update of the batch fails, the buffer pool is dropped and reloaded
+ *  from the write-ahead log, which no other operation may be using.
+ *
+ *  The cost is that readers and writers alike wait for the whole batch:
+ *  a batch of n updates stalls every other operation for n inserts or
+ *  deletes, plus a commit of the pages they dirtied. Readers needing a
+ *  consistent view of several keys get it either way, from a Snapshot
+ *  or from reads that never overlap a batch. Keep batches small on trees
+ *  serving latency sensitive reads.
+ */
+
+// Batch collects updates to be applied atomically by Tree.Write.
+// The zero value is an empty batch ready to use.
+type Batch struct {
+	ops []batchOp
+}
+
+type batchOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// Put adds storing value for key to the batch
+func (b *Batch) Put(key, value []byte) {
+	b.ops = append(b.ops, batchOp{
+		key:   append([]byte(nil), key...),
+		value: append([]byte(nil), value...),
+	})
+}
+
+// Delete adds removing key to the batch
+func (b *Batch) Delete(key []byte) {
+	b.ops = append(b.ops, batchOp{key: append([]byte(nil), key...), delete: true})
+}
+
+// Len returns the number of updates in the batch
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Reset empties the batch for reuse
+func (b *Batch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+// Write applies the updates of b in order. Readers observe either none
+// or all of them, and a crash never leaves part of the batch in place.
+// If an update fails, the batch is rolled back and none of it is applied.
+//
+// Other operations on the tree, readers included, wait until the batch is
+// written, see the notes at the top of this file.
+func (t *Tree) Write(b *Batch) error {
+	if err := t.writable(); err != nil {
+		return err
+	}
+	for _, op := range b.ops {
+		if err := checkKey(op.key, MaxKey); err != nil {
+			return err
+		}
+		if len(op.value) > t.opts.MaxValueSize {
+			return fmt.Errorf("%w: %d bytes", ErrValueTooLarge, len(op.value))
+		}
+	}
+
+	tree := t.handle()
+	defer t.release(tree)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// commit the updates before the batch, so that
+	// a failed batch rolls back to this point
+	if err := t.mgr.Commit(false); err != nil {
+		return err
+	}
+
+	for _, op := range b.ops {
+		var err error
+		if op.delete {
+			err = tree.deleteKey(op.key, 0)
+		} else {
+			err = tree.insertKey(op.key, 0, op.value, true)
+		}
+		if err != nil {
+			if rerr := t.mgr.Rollback(); rerr != nil {
+				return fmt.Errorf("%w (rollback: %w)", err, rerr)
+			}
+			return err
+		}
+	}
+
+	if t.opts.Durability == DurabilityNone {
+		return nil
+	}
+	return t.mgr.Commit(t.opts.Durability == DurabilityFsync)
+}
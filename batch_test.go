@@ -0,0 +1,251 @@
+package blinktree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestTree_Write(t *testing.T) {
+	_ = os.Remove("data/tree_write.db")
+	tree, err := Open("data/tree_write.db", Options{})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	if err := tree.Put([]byte("gone"), []byte("soon")); err != nil {
+		t.Fatalf("Put() err = %v", err)
+	}
+
+	var b Batch
+	key := []byte("a")
+	b.Put(key, []byte("1"))
+	key[0] = 'b' // the batch keeps its own copy
+	b.Put([]byte("c"), []byte("3"))
+	b.Delete([]byte("gone"))
+	b.Put([]byte("c"), []byte("33"))
+	if b.Len() != 4 {
+		t.Errorf("Len() = %d, want 4", b.Len())
+	}
+	if err := tree.Write(&b); err != nil {
+		t.Fatalf("Write() err = %v", err)
+	}
+
+	for key, want := range map[string]string{"a": "1", "c": "33"} {
+		if got, err := tree.Get([]byte(key)); err != nil || string(got) != want {
+			t.Errorf("Get(%q) = %q, %v, want %q", key, got, err, want)
+		}
+	}
+	for _, key := range []string{"b", "gone"} {
+		if _, err := tree.Get([]byte(key)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) err = %v, want %v", key, err, ErrNotFound)
+		}
+	}
+
+	b.Reset()
+	if b.Len() != 0 {
+		t.Errorf("Len() after Reset() = %d, want 0", b.Len())
+	}
+}
+
+func TestTree_Write_rollback(t *testing.T) {
+	_ = os.Remove("data/tree_write_rollback.db")
+	tree, err := Open("data/tree_write_rollback.db", Options{PageBits: BtMinBits, PoolSize: 16})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+
+	keyOf := func(i int) []byte {
+		return []byte(fmt.Sprintf("key%05d", i))
+	}
+	for i := 0; i < 100; i++ {
+		if err := tree.Put(keyOf(i), []byte("before")); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+
+	// enough updates to evict pages to the log before the last one
+	// fails with a key too large for the small pages
+	var b Batch
+	for i := 0; i < 300; i++ {
+		b.Put(keyOf(i), bytes.Repeat([]byte("after"), 10))
+		if i%2 == 0 {
+			b.Delete(keyOf(i))
+		}
+	}
+	b.Put(bytes.Repeat([]byte{'x'}, 200), []byte("too large"))
+	if err := tree.Write(&b); !errors.Is(err, ErrPageFull) {
+		t.Fatalf("Write() err = %v, want %v", err, ErrPageFull)
+	}
+
+	check := func(t *testing.T, tree *Tree) {
+		t.Helper()
+		for i := 0; i < 300; i++ {
+			got, err := tree.Get(keyOf(i))
+			if i < 100 {
+				if err != nil || string(got) != "before" {
+					t.Fatalf("Get(%q) = %q, %v, want %q", keyOf(i), got, err, "before")
+				}
+			} else if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Get(%q) = %q, %v, want %v", keyOf(i), got, err, ErrNotFound)
+			}
+		}
+	}
+	check(t, tree)
+
+	// the tree remains usable after the rollback
+	if err := tree.Put(keyOf(500), []byte("later")); err != nil {
+		t.Fatalf("Put() after rollback err = %v", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close() err = %v", err)
+	}
+
+	tree, err = Open("data/tree_write_rollback.db", Options{PoolSize: 16})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+	check(t, tree)
+	if got, err := tree.Get(keyOf(500)); err != nil || string(got) != "later" {
+		t.Errorf("Get(%q) = %q, %v, want %q", keyOf(500), got, err, "later")
+	}
+}
+
+func TestTree_Write_atomicVisibility(t *testing.T) {
+	_ = os.Remove("data/tree_write_atomic.db")
+	tree, err := Open("data/tree_write_atomic.db", Options{Durability: DurabilityNone})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	keys := 50
+	generations := uint64(100)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for gen := uint64(1); gen <= generations; gen++ {
+			var b Batch
+			value := make([]byte, 8)
+			binary.BigEndian.PutUint64(value, gen)
+			for i := 0; i < keys; i++ {
+				b.Put([]byte(fmt.Sprintf("key%03d", i)), value)
+			}
+			if err := tree.Write(&b); err != nil {
+				t.Errorf("Write() err = %v", err)
+				return
+			}
+		}
+	}()
+
+	// a reader holding the shared commit lock sees a single generation
+	reader := NewBLTree(tree.mgr)
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+
+		tree.mu.RLock()
+		var seen []byte
+		for i := 0; i < keys; i++ {
+			ret, _, value := reader.findKey([]byte(fmt.Sprintf("key%03d", i)), 8)
+			if reader.err != nil {
+				t.Errorf("findKey() err = %v", reader.err)
+			}
+			if i == 0 {
+				seen = value
+			} else if (ret < 0) != (seen == nil) || !bytes.Equal(value, seen) {
+				t.Errorf("key%03d = %v, key000 = %v in the same read", i, value, seen)
+			}
+		}
+		tree.mu.RUnlock()
+	}
+	wg.Wait()
+}
+
+func TestTree_Write_concurrentReaders(t *testing.T) {
+	tree, err := Open("", Options{InMemory: true, PageBits: 12})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	keys := 200
+	generations := uint64(50)
+	keyOf := func(i int) []byte {
+		return []byte(fmt.Sprintf("key%03d", i))
+	}
+
+	// readers through the public API run beside the batches
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			last := make([]uint64, keys)
+			for finished := false; !finished; {
+				select {
+				case <-done:
+					finished = true
+				default:
+				}
+
+				// a snapshot holds whole batches only
+				snap := tree.Snapshot()
+				var gen uint64
+				n := 0
+				err := snap.Scan(nil, nil, func(key, value []byte) bool {
+					if n == 0 {
+						gen = binary.BigEndian.Uint64(value)
+					} else if got := binary.BigEndian.Uint64(value); got != gen {
+						t.Errorf("reader %d: %s at generation %d, first key at %d", r, key, got, gen)
+						return false
+					}
+					n++
+					return true
+				})
+				snap.Release()
+				if err != nil || n != 0 && n != keys {
+					t.Errorf("reader %d: Scan() visited %d keys, %v, want %d", r, n, err, keys)
+					return
+				}
+
+				// single keys never go back to an older batch
+				i := int(gen) % keys
+				if value, err := tree.Get(keyOf(i)); err == nil {
+					got := binary.BigEndian.Uint64(value)
+					if got < last[i] {
+						t.Errorf("reader %d: key %d went back from generation %d to %d", r, i, last[i], got)
+					}
+					last[i] = got
+				}
+			}
+		}(r)
+	}
+
+	for gen := uint64(1); gen <= generations; gen++ {
+		var b Batch
+		value := binary.BigEndian.AppendUint64(nil, gen)
+		for i := 0; i < keys; i++ {
+			b.Put(keyOf(i), value)
+		}
+		if err := tree.Write(&b); err != nil {
+			t.Fatalf("Write() err = %v", err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
@@ -1,14 +1,39 @@
-package main
+package blinktree
 
-type BLTErr int
+import "errors"
 
-const (
-	BLTErrOk BLTErr = iota
-	BLTErrStruct
-	BLTErrOverflow
-	BLTErrLock
-	BLTErrMap
-	BLTErrRead
-	BLTErrWrite
-	BLTErrAtomic
+var (
+	// ErrNotFound is returned when a key is not in the tree
+	ErrNotFound = errors.New("blinktree: key not found")
+	// ErrCorrupt is returned when a page or the tree structure is inconsistent
+	ErrCorrupt = errors.New("blinktree: corrupt tree structure")
+	// ErrKeyTooLarge is returned when a key exceeds MaxKey bytes
+	ErrKeyTooLarge = errors.New("blinktree: key too large")
+	// ErrReservedKey is returned when a key is not below the stopper key
+	// 0xff 0xff, which ends the last page of each level
+	ErrReservedKey = errors.New("blinktree: key reserved for the stopper key")
+	// ErrValueTooLarge is returned when a value exceeds the allowed size
+	ErrValueTooLarge = errors.New("blinktree: value too large")
+	// ErrPageFull is returned when a key and value cannot fit on a single page
+	ErrPageFull = errors.New("blinktree: entry does not fit on a page")
+	// ErrRead is returned when a page cannot be read from the tree file
+	ErrRead = errors.New("blinktree: unable to read page")
+	// ErrWrite is returned when a page cannot be written to the tree file
+	ErrWrite = errors.New("blinktree: unable to write page")
+	// ErrChecksum is returned along with ErrCorrupt when a page read from
+	// disk does not match its checksum, e.g. after a torn write
+	ErrChecksum = errors.New("blinktree: page checksum mismatch")
+	// ErrVersion is returned when the tree file was written in another format
+	ErrVersion = errors.New("blinktree: unsupported file format version")
+	// ErrReadOnly is returned when updating a tree opened read-only
+	ErrReadOnly = errors.New("blinktree: tree is read-only")
+	// ErrLocked is returned when the tree file is already open in another
+	// process, or read-write in this one
+	ErrLocked = errors.New("blinktree: tree file is locked by another open")
+	// ErrNotEmpty is returned when bulk loading a tree that holds keys
+	ErrNotEmpty = errors.New("blinktree: tree is not empty")
+	// ErrInvalidOption is returned by Open when an option is out of range
+	ErrInvalidOption = errors.New("blinktree: invalid option")
+	// ErrUnsorted is returned when bulk loaded keys are not in ascending order
+	ErrUnsorted = errors.New("blinktree: keys are not in ascending order")
 )
@@ -1,8 +1,8 @@
-package main
+package blinktree
 
 import (
+	"fmt"
 	"log"
-	"sync/atomic"
 )
 
 type BLTree struct {
@@ -12,7 +12,9 @@ type BLTree struct {
 	// frame      *Page          // spare frame for the page split (never mapped)
 	cursorPage uid // current cursor page number
 	//found      bool   // last delete or insert was found (Note: not used)
-	err BLTErr //last error
+	err  error     //last error
+	dup  uid       // duplicate key unique id of the last duplicate inserted
+	snap *snapshot // read pages as of this snapshot when set
 	//key        [KeyArray]byte // last found complete key (Note: not used)
 	reads  uint // number of reads from the btree
 	writes uint // number of writes to the btree
@@ -28,8 +30,8 @@ type BLTree struct {
  *
  *  A key consists of a length byte, two bytes of index number (0 - 65534),
  *  and up to 253 bytes of key value.  Duplicate keys are discarded.
- *  Associated with each key is an opaque value of any size small enough
- *  to fit in a page.
+ *  Associated with each key is an opaque value of any size. Values
+ *  too large to fit in a page are kept on a chain of overflow pages.
  *
  *  The b-tree root is always located at page 1.  The first leaf page of
  *  level zero is always located on page 2.
@@ -64,6 +66,11 @@ type BLTree struct {
  *  use 1 based indexing.
  */
 
+// loadPage loads the page for key at given level through the snapshot of tree
+func (tree *BLTree) loadPage(set *PageSet, key []byte, lvl uint8, lock BLTLockMode) (uint32, error) {
+	return tree.mgr.loadPage(set, key, lvl, lock, tree.snap, &tree.reads, &tree.writes)
+}
+
 // NewBLTree open BTree access method based on buffer manager
 func NewBLTree(bufMgr *BufMgr) *BLTree {
 	tree := BLTree{
@@ -77,7 +84,7 @@ func NewBLTree(bufMgr *BufMgr) *BLTree {
 // fixFence
 // a fence key was deleted from a page,
 // push new fence value upwards
-func (tree *BLTree) fixFence(set *PageSet, lvl uint8) BLTErr {
+func (tree *BLTree) fixFence(set *PageSet, lvl uint8) error {
 	// remove the old fence value
 	rightKey := set.page.Key(set.page.Cnt)
 	set.page.ClearSlot(set.page.Cnt)
@@ -95,24 +102,24 @@ func (tree *BLTree) fixFence(set *PageSet, lvl uint8) BLTErr {
 
 	// insert new (now smaller) fence key
 
-	if err := tree.insertKey(leftKey, lvl+1, value, true); err != BLTErrOk {
+	if err := tree.insertKey(leftKey, lvl+1, value[:], true); err != nil {
 		return err
 	}
 
 	// now delete old fence key
-	if err := tree.deleteKey(rightKey, lvl+1); err != BLTErrOk {
+	if err := tree.deleteKey(rightKey, lvl+1); err != nil {
 		return err
 	}
 
 	tree.mgr.UnlockPage(LockParent, set.latch)
 	tree.mgr.UnpinLatch(set.latch)
-	return BLTErrOk
+	return nil
 }
 
 // collapseRoot
 // root has a single child
 // collapse a level from the tree
-func (tree *BLTree) collapseRoot(root *PageSet) BLTErr {
+func (tree *BLTree) collapseRoot(root *PageSet) error {
 	var child PageSet
 	var pageNo uid
 	var idx uint32
@@ -127,12 +134,11 @@ func (tree *BLTree) collapseRoot(root *PageSet) BLTErr {
 		}
 
 		pageNo = GetIDFromValue(root.page.Value(idx))
-		child.latch = tree.mgr.PinLatch(pageNo, true, &tree.reads, &tree.writes)
-		if child.latch != nil {
-			child.page = tree.mgr.MapPage(child.latch)
-		} else {
-			return tree.err
+		var err error
+		if child.latch, err = tree.mgr.PinLatch(pageNo, true, &tree.reads, &tree.writes); err != nil {
+			return err
 		}
+		child.page = tree.mgr.MapPage(child.latch)
 
 		tree.mgr.LockPage(LockDelete, child.latch)
 		tree.mgr.LockPage(LockWrite, child.latch)
@@ -148,7 +154,7 @@ func (tree *BLTree) collapseRoot(root *PageSet) BLTErr {
 
 	tree.mgr.UnlockPage(LockWrite, root.latch)
 	tree.mgr.UnpinLatch(root.latch)
-	return BLTErrOk
+	return nil
 }
 
 // deletePage
@@ -156,19 +162,18 @@ func (tree *BLTree) collapseRoot(root *PageSet) BLTErr {
 // delete a page and manage keys
 // call with page writelocked
 // returns with page unpinned
-func (tree *BLTree) deletePage(set *PageSet, mode BLTLockMode) BLTErr {
+func (tree *BLTree) deletePage(set *PageSet, mode BLTLockMode) error {
 	var right PageSet
 	// cache copy of fence key to post in parent
 	lowerFence := set.page.Key(set.page.Cnt)
 
 	// obtain lock on right page
 	pageNo := GetID(&set.page.Right)
-	right.latch = tree.mgr.PinLatch(pageNo, true, &tree.reads, &tree.writes)
-	if right.latch != nil {
-		right.page = tree.mgr.MapPage(right.latch)
-	} else {
-		return BLTErrOk
+	var err error
+	if right.latch, err = tree.mgr.PinLatch(pageNo, true, &tree.reads, &tree.writes); err != nil {
+		return err
 	}
+	right.page = tree.mgr.MapPage(right.latch)
 
 	tree.mgr.LockPage(LockWrite, right.latch)
 	tree.mgr.LockPage(mode, right.latch)
@@ -177,8 +182,7 @@ func (tree *BLTree) deletePage(set *PageSet, mode BLTLockMode) BLTErr {
 	higherFence := right.page.Key(right.page.Cnt)
 
 	if right.page.Kill {
-		tree.err = BLTErrStruct
-		return tree.err
+		return fmt.Errorf("%w: right page %d already killed", ErrCorrupt, pageNo)
 	}
 
 	// pull contents of right peer into our empty page
@@ -202,12 +206,12 @@ func (tree *BLTree) deletePage(set *PageSet, mode BLTLockMode) BLTErr {
 	tree.mgr.LockPage(LockParent, set.latch)
 	tree.mgr.UnlockPage(LockWrite, set.latch)
 
-	if err := tree.insertKey(higherFence, set.page.Lvl+1, value, true); err != BLTErrOk {
+	if err := tree.insertKey(higherFence, set.page.Lvl+1, value[:], true); err != nil {
 		return err
 	}
 
 	// delete old lower key to our node
-	if err := tree.deleteKey(lowerFence, set.page.Lvl+1); err != BLTErrOk {
+	if err := tree.deleteKey(lowerFence, set.page.Lvl+1); err != nil {
 		return err
 	}
 
@@ -219,18 +223,18 @@ func (tree *BLTree) deletePage(set *PageSet, mode BLTLockMode) BLTErr {
 	tree.mgr.UnlockPage(LockParent, set.latch)
 	tree.mgr.UnpinLatch(set.latch)
 	//tree.found = true
-	return BLTErrOk
+	return nil
 }
 
 // deleteKey
 //
 // find and delete key on page by marking delete flag bit
 // if page becomes empty, delete it from the btree
-func (tree *BLTree) deleteKey(key []byte, lvl uint8) BLTErr {
+func (tree *BLTree) deleteKey(key []byte, lvl uint8) error {
 	var set PageSet
-	slot := tree.mgr.LoadPage(&set, key, lvl, LockWrite, &tree.reads, &tree.writes)
-	if slot == 0 {
-		return tree.err
+	slot, err := tree.mgr.LoadPage(&set, key, lvl, LockWrite, &tree.reads, &tree.writes)
+	if err != nil {
+		return err
 	}
 	ptr := set.page.Key(slot)
 
@@ -248,6 +252,17 @@ func (tree *BLTree) deleteKey(key []byte, lvl uint8) BLTErr {
 		found = !set.page.Dead(slot)
 		if found {
 			val := *set.page.Value(slot)
+
+			// release the overflow chain of the value
+			if set.page.Overflow(slot) {
+				if err := tree.freeOverflow(val); err != nil {
+					tree.mgr.UnlockPage(LockWrite, set.latch)
+					tree.mgr.UnpinLatch(set.latch)
+					return err
+				}
+				set.page.SetOverflow(slot, false)
+			}
+
 			set.page.SetDead(slot, true)
 			set.page.Garbage += uint32(1+len(ptr)) + uint32(1+len(val))
 			set.page.Act--
@@ -270,20 +285,12 @@ func (tree *BLTree) deleteKey(key []byte, lvl uint8) BLTErr {
 
 	// did we delete a fence key in an upper level?
 	if found && lvl > 0 && set.page.Act > 0 && fence {
-		if err := tree.fixFence(&set, lvl); err != BLTErrOk {
-			return err
-		} else {
-			return BLTErrOk
-		}
+		return tree.fixFence(&set, lvl)
 	}
 
 	// do we need to collapse root?
 	if lvl > 1 && set.latch.pageNo == RootPage && set.page.Act == 1 {
-		if err := tree.collapseRoot(&set); err != BLTErrOk {
-			return err
-		} else {
-			return BLTErrOk
-		}
+		return tree.collapseRoot(&set)
 	}
 
 	// delete empty page
@@ -293,7 +300,7 @@ func (tree *BLTree) deleteKey(key []byte, lvl uint8) BLTErr {
 	set.latch.dirty = true
 	tree.mgr.UnlockPage(LockWrite, set.latch)
 	tree.mgr.UnpinLatch(set.latch)
-	return BLTErrOk
+	return nil
 }
 
 // findNext
@@ -305,17 +312,17 @@ func (tree *BLTree) findNext(set *PageSet, slot uint32) uint32 {
 	}
 	prevLatch := set.latch
 	pageNo := GetID(&set.page.Right)
-	if pageNo > 0 {
-		set.latch = tree.mgr.PinLatch(pageNo, true, &tree.reads, &tree.writes)
-		if set.latch != nil {
-			set.page = tree.mgr.MapPage(set.latch)
-		} else {
-			return 0
-		}
-	} else {
-		tree.err = BLTErrStruct
+	if pageNo == 0 {
+		tree.err = fmt.Errorf("%w: end of right chain", ErrCorrupt)
 		return 0
 	}
+	latch, err := tree.mgr.PinLatch(pageNo, true, &tree.reads, &tree.writes)
+	if err != nil {
+		tree.err = err
+		return 0
+	}
+	set.latch = latch
+	set.page = tree.mgr.MapPage(set.latch)
 
 	// obtain access lock using lock chaining with Access mode
 	tree.mgr.LockPage(LockAccess, set.latch)
@@ -323,6 +330,7 @@ func (tree *BLTree) findNext(set *PageSet, slot uint32) uint32 {
 	tree.mgr.UnpinLatch(prevLatch)
 	tree.mgr.LockPage(LockRead, set.latch)
 	tree.mgr.UnlockPage(LockAccess, set.latch)
+	set.page = tree.snap.view(set.latch, set.page)
 	return 1
 }
 
@@ -334,7 +342,12 @@ func (tree *BLTree) findNext(set *PageSet, slot uint32) uint32 {
 func (tree *BLTree) findKey(key []byte, valMax int) (ret int, foundKey []byte, foundValue []byte) {
 	var set PageSet
 	ret = -1
-	slot := tree.mgr.LoadPage(&set, key, 0, LockRead, &tree.reads, &tree.writes)
+	tree.err = nil
+	slot, err := tree.loadPage(&set, key, 0, LockRead)
+	if err != nil {
+		tree.err = err
+		return ret, nil, nil
+	}
 	for ; slot > 0; slot = tree.findNext(&set, slot) {
 		ptr := set.page.Key(slot)
 
@@ -370,6 +383,13 @@ func (tree *BLTree) findKey(key []byte, valMax int) (ret int, foundKey []byte, f
 		if keyLen == len(key) {
 			if KeyCmp(ptr[:keyLen], key) == 0 {
 				val := *set.page.Value(slot)
+				if set.page.Overflow(slot) {
+					var err error
+					if val, err = tree.readOverflow(val); err != nil {
+						tree.err = err
+						break
+					}
+				}
 				if valMax > len(val) {
 					valMax = len(val)
 				}
@@ -435,7 +455,8 @@ func (tree *BLTree) cleanPage(set *PageSet, keyLen uint8, slot uint32, valLen ui
 				newSlot = idx + 2
 			}
 		}
-		if cnt < max && frame.Dead(cnt) {
+		// a dead key being updated is kept, the update revives it
+		if cnt < max && frame.Dead(cnt) && (cnt != slot || frame.Typ(cnt) == Librarian) {
 			continue
 		}
 
@@ -467,8 +488,12 @@ func (tree *BLTree) cleanPage(set *PageSet, keyLen uint8, slot uint32, valLen ui
 		}
 
 		page.SetDead(idx, frame.Dead(cnt))
+		page.SetOverflow(idx, frame.Overflow(cnt))
 		if !page.Dead(idx) {
 			page.Act++
+		} else {
+			// the dead fence key or updated key is kept as garbage
+			page.Garbage += uint32(len(key)+len(val)) + 2
 		}
 	}
 
@@ -486,7 +511,7 @@ func (tree *BLTree) cleanPage(set *PageSet, keyLen uint8, slot uint32, valLen ui
 // splitRoot
 //
 // split the root and raise the height of the btree
-func (tree *BLTree) splitRoot(root *PageSet, right *LatchSet) BLTErr {
+func (tree *BLTree) splitRoot(root *PageSet, right *LatchSet) error {
 	var left PageSet
 	nxt := tree.mgr.pageDataSize
 	var value [BtId]byte
@@ -495,7 +520,7 @@ func (tree *BLTree) splitRoot(root *PageSet, right *LatchSet) BLTErr {
 
 	// Obtain an empty page to use, and copy the current
 	// root contents into it, e.g. lower keys
-	if err := tree.mgr.NewPage(&left, root.page, &tree.reads, &tree.writes); err != BLTErrOk {
+	if err := tree.mgr.NewPage(&left, root.page, &tree.reads, &tree.writes); err != nil {
 		return err
 	}
 
@@ -535,22 +560,64 @@ func (tree *BLTree) splitRoot(root *PageSet, right *LatchSet) BLTErr {
 	tree.mgr.UnlockPage(LockWrite, root.latch)
 	tree.mgr.UnpinLatch(root.latch)
 	tree.mgr.UnpinLatch(right)
-	return BLTErrOk
+	return nil
+}
+
+// appending reports whether inserting ins into the locked full page
+// extends the rightmost page of its level past its last key
+func appending(page *Page, ins []byte) bool {
+	if GetID(&page.Right) > 0 {
+		return false
+	}
+	for slot := page.Cnt - 1; slot > 0; slot-- {
+		if page.Typ(slot) != Librarian {
+			return KeyCmp(ins, page.Key(slot)) > 0
+		}
+	}
+	return false
+}
+
+// splitPoint returns the last slot of the full page kept on the left page
+// by a split. Pages are split in half, except when keys are appended to
+// the rightmost page: then 90 percent of the slots stay on the left page,
+// as far as its keys fit with their librarian slots, so that ascending
+// inserts leave full pages behind instead of half empty ones.
+func (tree *BLTree) splitPoint(page *Page, ins []byte) uint32 {
+	split := page.Cnt / 2
+	if !appending(page, ins) {
+		return split
+	}
+
+	var size uint32
+	for slot := uint32(1); slot <= page.Cnt*9/10; slot++ {
+		if !page.Dead(slot) {
+			size += 2*SlotSize + 2 + uint32(len(page.Key(slot))+len(*page.Value(slot)))
+		}
+		if size > tree.mgr.pageDataSize {
+			break
+		}
+		if slot > split {
+			split = slot
+		}
+	}
+	return split
 }
 
 // splitPage
 //
 // split already locked full node; leave it locked.
+// ins is the key that did not fit, it decides the split point.
 // @return pool entry for new right page, unlocked
-func (tree *BLTree) splitPage(set *PageSet) uint {
+func (tree *BLTree) splitPage(set *PageSet, ins []byte) uint {
 	nxt := tree.mgr.pageDataSize
 	lvl := set.page.Lvl
 	var right PageSet
 
-	// split higher half of keys to frame
+	// split keys above the split point to frame
 	frame := NewPage(tree.mgr.pageDataSize)
 	max := set.page.Cnt
-	cnt := max / 2
+	split := tree.splitPoint(set.page, ins)
+	cnt := split
 	idx := uint32(0)
 
 	for cnt < max {
@@ -583,8 +650,12 @@ func (tree *BLTree) splitPage(set *PageSet) uint {
 		frame.SetTyp(idx, set.page.Typ(cnt))
 
 		frame.SetDead(idx, set.page.Dead(cnt))
+		frame.SetOverflow(idx, set.page.Overflow(cnt))
 		if !frame.Dead(idx) {
 			frame.Act++
+		} else {
+			// the dead fence key is kept as garbage
+			frame.Garbage += uint32(len(key)) + valLen + 2
 		}
 	}
 
@@ -599,7 +670,8 @@ func (tree *BLTree) splitPage(set *PageSet) uint {
 	}
 
 	// get new free page and write higher keys to it.
-	if err := tree.mgr.NewPage(&right, frame, &tree.reads, &tree.writes); err != BLTErrOk {
+	if err := tree.mgr.NewPage(&right, frame, &tree.reads, &tree.writes); err != nil {
+		tree.err = err
 		return 0
 	}
 
@@ -610,7 +682,7 @@ func (tree *BLTree) splitPage(set *PageSet) uint {
 	nxt = tree.mgr.pageDataSize
 	set.page.Garbage = 0
 	set.page.Act = 0
-	max /= 2
+	max = split
 	cnt = 0
 	idx = 0
 
@@ -644,6 +716,7 @@ func (tree *BLTree) splitPage(set *PageSet) uint {
 		idx++
 		set.page.SetKeyOffset(idx, nxt)
 		set.page.SetTyp(idx, frame.Typ(cnt))
+		set.page.SetOverflow(idx, frame.Overflow(cnt))
 		set.page.Act++
 	}
 
@@ -659,7 +732,7 @@ func (tree *BLTree) splitPage(set *PageSet) uint {
 // fix keys for newly split page
 // call with page locked
 // @return unlocked
-func (tree *BLTree) splitKeys(set *PageSet, right *LatchSet) BLTErr {
+func (tree *BLTree) splitKeys(set *PageSet, right *LatchSet) error {
 	lvl := set.page.Lvl
 
 	// if current page is the root page, split it
@@ -682,14 +755,14 @@ func (tree *BLTree) splitKeys(set *PageSet, right *LatchSet) BLTErr {
 	var value [BtId]byte
 	PutID(&value, set.latch.pageNo)
 
-	if err := tree.insertKey(leftKey, lvl+1, value, true); err != BLTErrOk {
+	if err := tree.insertKey(leftKey, lvl+1, value[:], true); err != nil {
 		return err
 	}
 
 	// switch fence for right block of larger keys to new right page
 	PutID(&value, right.pageNo)
 
-	if err := tree.insertKey(rightKey, lvl+1, value, true); err != BLTErrOk {
+	if err := tree.insertKey(rightKey, lvl+1, value[:], true); err != nil {
 		return err
 	}
 
@@ -697,7 +770,7 @@ func (tree *BLTree) splitKeys(set *PageSet, right *LatchSet) BLTErr {
 	tree.mgr.UnpinLatch(set.latch)
 	tree.mgr.UnlockPage(LockParent, right)
 	tree.mgr.UnpinLatch(right)
-	return BLTErrOk
+	return nil
 }
 
 // insertSlot install new key and value onto page.
@@ -706,10 +779,11 @@ func (tree *BLTree) insertSlot(
 	set *PageSet,
 	slot uint32,
 	key []byte,
-	value [BtId]byte,
+	value []byte,
 	typ SlotType,
+	overflow bool,
 	release bool,
-) BLTErr {
+) error {
 	// if found slot > desired slot and previous slot is a librarian slot, use it
 	if slot > 1 {
 		if set.page.Typ(slot-1) == Librarian {
@@ -719,7 +793,7 @@ func (tree *BLTree) insertSlot(
 
 	// copy value onto page
 	set.page.Min -= uint32(len(value)) + 1
-	copy(set.page.Data[set.page.Min:], append([]byte{byte(len(value))}, value[:]...))
+	copy(set.page.Data[set.page.Min:], append([]byte{byte(len(value))}, value...))
 
 	// copy key onto page
 	set.page.Min -= uint32(len(key) + 1)
@@ -748,6 +822,7 @@ func (tree *BLTree) insertSlot(
 	// move slots up to make room for new key
 	for idx > slot+librarian-1 {
 		set.page.SetDead(idx, set.page.Dead(idx-librarian))
+		set.page.SetOverflow(idx, set.page.Overflow(idx-librarian))
 		set.page.SetTyp(idx, set.page.Typ(idx-librarian))
 		set.page.SetKeyOffset(idx, set.page.KeyOffset(idx-librarian))
 		idx--
@@ -758,6 +833,7 @@ func (tree *BLTree) insertSlot(
 		set.page.SetKeyOffset(slot, set.page.Min)
 		set.page.SetTyp(slot, Librarian)
 		set.page.SetDead(slot, true)
+		set.page.SetOverflow(slot, false)
 		slot++
 	}
 
@@ -765,55 +841,73 @@ func (tree *BLTree) insertSlot(
 	set.page.SetKeyOffset(slot, set.page.Min)
 	set.page.SetTyp(slot, typ)
 	set.page.SetDead(slot, false)
+	set.page.SetOverflow(slot, overflow)
 
 	if release {
 		tree.mgr.UnlockPage(LockWrite, set.latch)
 		tree.mgr.UnpinLatch(set.latch)
 	}
 
-	return BLTErrOk
+	return nil
 }
 
 // newDup
 func (tree *BLTree) newDup() uid {
-	return uid(atomic.AddUint64(&tree.mgr.pageZero.dups, 1))
+	return tree.mgr.NewDup()
 }
 
 // insertKey insert new key into the btree at given level. either add a new key or update/add an existing one
-func (tree *BLTree) insertKey(key []byte, lvl uint8, value [BtId]byte, uniq bool) BLTErr {
+func (tree *BLTree) insertKey(key []byte, lvl uint8, value []byte, uniq bool) error {
+	if uniq {
+		return tree.insertEntry(key, lvl, value, Unique)
+	}
+
+	// a non-unique index value is extended by a new unique id
+	tree.dup = tree.newDup()
+	return tree.insertEntry(dupKey(key, tree.dup), lvl, value, Duplicate)
+}
+
+// insertEntry
+//
+// insert the stored key ins of type typ, a duplicate key including
+// its unique id, or update the value of ins if it is already there
+func (tree *BLTree) insertEntry(ins []byte, lvl uint8, value []byte, typ SlotType) error {
 	var slot uint32
 	var keyLen uint8
 	var set PageSet
-	ins := key
 	var ptr []byte
-	var sequence uid
-	var typ SlotType
 
-	// is this a non-unique index value?
-	if uniq {
-		typ = Unique
-	} else {
-		typ = Duplicate
-		sequence = tree.newDup()
-		var seqBytes [BtId]byte
-		PutID(&seqBytes, sequence)
-		ins = append(ins, seqBytes[:]...)
+	// leaf values too large for the page go to overflow pages
+	overflow := lvl == 0 && len(value) > tree.mgr.maxInline()
+	valLen := len(value)
+	if overflow {
+		valLen = overflowRefSize
+	}
+
+	// the entry must leave room on the page for a split
+	if uint32(len(ins)+valLen+2)+2*SlotSize > tree.mgr.pageDataSize/4 {
+		return fmt.Errorf("%w: key %d bytes, value %d bytes", ErrPageFull, len(ins), len(value))
+	}
+
+	if overflow {
+		ref, err := tree.writeOverflow(value)
+		if err != nil {
+			return err
+		}
+		value = ref
 	}
 
 	for {
-		slot = tree.mgr.LoadPage(&set, key, lvl, LockWrite, &tree.reads, &tree.writes)
-		if slot > 0 {
-			ptr = set.page.Key(slot)
-		} else {
-			if tree.err != BLTErrOk {
-				tree.err = BLTErrOverflow
-			}
-			return tree.err
+		var err error
+		// duplicates are placed by their sequence number
+		if slot, err = tree.mgr.LoadPage(&set, ins, lvl, LockWrite, &tree.reads, &tree.writes); err != nil {
+			return tree.abandonOverflow(overflow, value, err)
 		}
+		ptr = set.page.Key(slot)
 
 		// if librarian slot == found slot, advance to real slot
 		if set.page.Typ(slot) == Librarian {
-			if KeyCmp(ptr, key) == 0 {
+			if KeyCmp(ptr, ins) == 0 {
 				slot++
 				ptr = set.page.Key(slot)
 			}
@@ -829,42 +923,107 @@ func (tree *BLTree) insertKey(key []byte, lvl uint8, value [BtId]byte, uniq bool
 		//   check for adequate space on the page
 		//   and insert the new key before slot.
 
-		if (uniq && (keyLen != uint8(len(ins)) || KeyCmp(ptr, ins) != 0)) || !uniq {
-			slot = tree.cleanPage(&set, uint8(len(ins)), slot, BtId)
+		if KeyCmp(ptr, ins) != 0 || typ == Unique && keyLen != uint8(len(ins)) {
+			slot = tree.cleanPage(&set, uint8(len(ins)), slot, uint8(len(value)))
 			if slot == 0 {
-				entry := tree.splitPage(&set)
-				if entry == 0 {
-					return tree.err
-				} else if err := tree.splitKeys(&set, &tree.mgr.latchSets[entry]); err != BLTErrOk {
-					return err
-				} else {
-					continue
+				if err := tree.splitFull(&set, ins); err != nil {
+					return tree.abandonOverflow(overflow, value, err)
 				}
+				continue
 			}
-			return tree.insertSlot(&set, slot, ins, value, typ, true)
+			return tree.insertSlot(&set, slot, ins, value, typ, overflow, true)
 		}
 
 		// if key already exists, update value and return
-		// Note: omit if-block for always true condition
-		//val := set.page.Value(slot)
-		//if len(val) >= len(value) {
-		if set.page.Dead(slot) {
-			set.page.Act++
+		val := *set.page.Value(slot)
+		replaced := !set.page.Dead(slot) && set.page.Overflow(slot)
+		if len(val) >= len(value) {
+			// a dead slot was counted as garbage when its key was deleted
+			if set.page.Dead(slot) {
+				set.page.Act++
+				set.page.Garbage -= uint32(len(ptr)+len(val)) + 2
+			}
+			set.page.Garbage += uint32(len(val) - len(value))
+			set.latch.dirty = true
+			set.page.SetDead(slot, false)
+			set.page.SetValue(value, slot)
+			set.page.SetOverflow(slot, overflow)
+			return tree.releaseUpdated(&set, replaced, val)
 		}
-		//set.page.Garbage += len(val) - len(value)
+
+		// new update value doesn't fit in existing value area,
+		// the slot is revived once the new entry is written
+		dead := set.page.Dead(slot)
+		slot = tree.cleanPage(&set, uint8(len(ptr)), slot, uint8(len(value)))
+		if slot == 0 {
+			if err := tree.splitFull(&set, ins); err != nil {
+				return tree.abandonOverflow(overflow, value, err)
+			}
+			continue
+		}
+
+		// copy value and key onto page and point the slot at them
+		set.page.Min -= uint32(len(value)) + 1
+		copy(set.page.Data[set.page.Min:], append([]byte{byte(len(value))}, value...))
+
 		set.latch.dirty = true
-		set.page.SetDead(slot, false)
-		set.page.SetValue(value[:], slot)
+		set.page.Min -= uint32(len(ptr)) + 1
+		copy(set.page.Data[set.page.Min:], append([]byte{byte(len(ptr))}, ptr...))
+
+		set.page.SetKeyOffset(slot, set.page.Min)
+		set.page.SetOverflow(slot, overflow)
+
+		// the old entry is left behind as garbage, a dead
+		// one was counted when its key was deleted
+		if dead {
+			set.page.SetDead(slot, false)
+			set.page.Act++
+		} else {
+			set.page.Garbage += uint32(len(val)+len(ptr)) + 2
+		}
+		return tree.releaseUpdated(&set, replaced, val)
+	}
+}
+
+// releaseUpdated
+//
+// free the overflow chain of a replaced value
+// and release the write locked leaf page
+func (tree *BLTree) releaseUpdated(set *PageSet, replaced bool, val []byte) error {
+	var err error
+	if replaced {
+		err = tree.freeOverflow(val)
+	}
+
+	tree.mgr.UnlockPage(LockWrite, set.latch)
+	tree.mgr.UnpinLatch(set.latch)
+	return err
+}
+
+// abandonOverflow
+//
+// free the overflow chain of a value that could not be inserted
+func (tree *BLTree) abandonOverflow(overflow bool, ref []byte, err error) error {
+	if overflow {
+		_ = tree.freeOverflow(ref)
+	}
+	return err
+}
+
+// splitFull
+//
+// split a write locked page that has no room left
+// and post the new fence keys into the parent
+// @return unlocked
+func (tree *BLTree) splitFull(set *PageSet, ins []byte) error {
+	entry := tree.splitPage(set, ins)
+	if entry == 0 {
 		tree.mgr.UnlockPage(LockWrite, set.latch)
 		tree.mgr.UnpinLatch(set.latch)
-		return BLTErrOk
-		//}
-
-		// new update value doesn't fit in existing value area
-		// Note: omit logic for unreachable code
+		return tree.err
 	}
 
-	//return BLTErrOk
+	return tree.splitKeys(set, &tree.mgr.latchSets[entry])
 }
 
 // iterator methods
@@ -894,21 +1053,21 @@ func (tree *BLTree) nextKey(slot uint32) uint32 {
 
 		tree.cursorPage = right
 
-		set.latch = tree.mgr.PinLatch(right, true, &tree.reads, &tree.writes)
-		if set.latch != nil {
-			set.page = tree.mgr.MapPage(set.latch)
-		} else {
+		var err error
+		if set.latch, err = tree.mgr.PinLatch(right, true, &tree.reads, &tree.writes); err != nil {
+			tree.err = err
 			return 0
 		}
+		set.page = tree.mgr.MapPage(set.latch)
 
 		tree.mgr.LockPage(LockRead, set.latch)
-		MemCpyPage(tree.cursor, set.page)
+		MemCpyPage(tree.cursor, tree.snap.view(set.latch, set.page))
 		tree.mgr.UnlockPage(LockRead, set.latch)
 		tree.mgr.UnpinLatch(set.latch)
 		slot = 0
 	}
 
-	tree.err = BLTErrOk
+	tree.err = nil
 	return 0
 }
 
@@ -917,15 +1076,134 @@ func (tree *BLTree) startKey(key []byte) uint32 {
 	var set PageSet
 
 	// cache page for retrieval
-	slot := tree.mgr.LoadPage(&set, key, 0, LockRead, &tree.reads, &tree.writes)
-	if slot > 0 {
-		MemCpyPage(tree.cursor, set.page)
-	} else {
+	slot, err := tree.loadPage(&set, key, 0, LockRead)
+	if err != nil {
+		tree.err = err
 		return 0
 	}
+	MemCpyPage(tree.cursor, set.page)
 
 	tree.cursorPage = set.latch.pageNo
 	tree.mgr.UnlockPage(LockRead, set.latch)
 	tree.mgr.UnpinLatch(set.latch)
 	return slot
 }
+
+// prevKey
+//
+// cache the leaf page holding the last key before the given key into
+// cursor and return its slot, or 0 when there is no such key. When
+// inclusive is set the given key itself, or any duplicate of it, qualifies.
+func (tree *BLTree) prevKey(key []byte, inclusive bool) uint32 {
+	// duplicates sort after their key, start behind the last one
+	seek := make([]byte, len(key), len(key)+BtId)
+	copy(seek, key)
+	seek = append(seek, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+
+	return tree.prevSlot(seek, func(ptr []byte, typ SlotType) bool {
+		if typ == Duplicate {
+			ptr = ptr[:len(ptr)-BtId]
+		}
+		cmp := KeyCmp(ptr, key)
+		return cmp < 0 || inclusive && cmp == 0
+	})
+}
+
+// prevEntry
+//
+// cache the leaf page holding the entry stored before the given
+// key, compared as stored including a duplicate suffix, into cursor
+// and return its slot, or 0 when there is no such entry
+func (tree *BLTree) prevEntry(raw []byte) uint32 {
+	return tree.prevSlot(raw, func(ptr []byte, _ SlotType) bool {
+		return KeyCmp(ptr, raw) < 0
+	})
+}
+
+// prevSlot
+//
+// walk back from the slot found for seek to the last live
+// key accepted by before, moving to preceding leaf pages
+func (tree *BLTree) prevSlot(seek []byte, before func(ptr []byte, typ SlotType) bool) uint32 {
+	for {
+		slot := tree.startKey(seek)
+		if slot == 0 {
+			return 0
+		}
+
+		// skip librarian slot place holder
+		if tree.cursor.Typ(slot) == Librarian && slot < tree.cursor.Cnt {
+			slot++
+		}
+
+		for ; slot > 0; slot-- {
+			if tree.cursor.Dead(slot) || tree.cursor.Typ(slot) == Librarian {
+				continue
+			}
+			// skip infinite stopper
+			if slot == tree.cursor.Cnt && GetID(&tree.cursor.Right) == 0 {
+				continue
+			}
+			if before(tree.cursor.Key(slot), tree.cursor.Typ(slot)) {
+				return slot
+			}
+		}
+
+		// continue on the preceding leaf page, it holds
+		// the keys up to and including its fence key
+		fence := tree.prevFence(seek)
+		if fence == nil {
+			return 0
+		}
+		seek = fence
+	}
+}
+
+// prevFence
+//
+// return the fence key of the leaf page preceding the leaf page
+// that holds the given key, or nil at the first leaf page
+func (tree *BLTree) prevFence(key []byte) []byte {
+	var set PageSet
+
+	for lvl := uint8(1); ; lvl++ {
+		// stop above the root level
+		latch, err := tree.mgr.PinLatch(RootPage, true, &tree.reads, &tree.writes)
+		if err != nil {
+			tree.err = err
+			return nil
+		}
+		tree.mgr.LockPage(LockRead, latch)
+		rootLvl := tree.snap.view(latch, tree.mgr.MapPage(latch)).Lvl
+		tree.mgr.UnlockPage(LockRead, latch)
+		tree.mgr.UnpinLatch(latch)
+
+		if lvl > rootLvl {
+			return nil
+		}
+
+		// the live slot before the child holding key is the fence
+		// of the preceding page, and so of its rightmost leaf page
+		slot, err := tree.loadPage(&set, key, lvl, LockRead)
+		if err != nil {
+			tree.err = err
+			return nil
+		}
+
+		var fence []byte
+		for slot--; slot > 0; slot-- {
+			if !set.page.Dead(slot) {
+				fence = make([]byte, len(set.page.Key(slot)))
+				copy(fence, set.page.Key(slot))
+				break
+			}
+		}
+
+		tree.mgr.UnlockPage(LockRead, set.latch)
+		tree.mgr.UnpinLatch(set.latch)
+
+		if fence != nil {
+			return fence
+		}
+	}
+}
@@ -1,15 +1,25 @@
-package main
+package blinktree
 
 import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math/rand"
 	"os"
 	"sync"
 	"testing"
 	"time"
 )
 
+func newTestBufMgr(t *testing.T, name string, bits uint8, nodeMax uint) *BufMgr {
+	t.Helper()
+	mgr, err := NewBufMgr(name, BtRW, bits, nodeMax)
+	if err != nil {
+		t.Fatalf("NewBufMgr() err = %v", err)
+	}
+	return mgr
+}
+
 func TestBLTree_collapseRoot(t *testing.T) {
 	_ = os.Remove("data/collapse_root_test.db")
 
@@ -19,14 +29,14 @@ func TestBLTree_collapseRoot(t *testing.T) {
 	tests := []struct {
 		name   string
 		fields fields
-		want   BLTErr
+		want   error
 	}{
 		{
 			name: "collapse root",
 			fields: fields{
-				mgr: NewBufMgr("data/collapse_root_test.db", 13, 20),
+				mgr: newTestBufMgr(t, "data/collapse_root_test.db", 13, 20),
 			},
-			want: BLTErrOk,
+			want: nil,
 		},
 	}
 	for _, tt := range tests {
@@ -36,8 +46,8 @@ func TestBLTree_collapseRoot(t *testing.T) {
 				{1, 1, 1, 1},
 				{1, 1, 1, 2},
 			} {
-				if err := tree.insertKey(key, 0, [BtId]byte{1}, true); err != BLTErrOk {
-					t.Errorf("insertKey() = %v, want %v", err, BLTErrOk)
+				if err := tree.insertKey(key, 0, []byte{1}, true); err != nil {
+					t.Errorf("insertKey() = %v, want %v", err, nil)
 				}
 
 			}
@@ -48,7 +58,7 @@ func TestBLTree_collapseRoot(t *testing.T) {
 				t.Errorf("childAct = %v, want %v", childAct, 3)
 			}
 			var set PageSet
-			set.latch = tree.mgr.PinLatch(RootPage, true, &tree.reads, &tree.writes)
+			set.latch, _ = tree.mgr.PinLatch(RootPage, true, &tree.reads, &tree.writes)
 			set.page = tree.mgr.MapPage(set.latch)
 			if got := tree.collapseRoot(&set); got != tt.want {
 				t.Errorf("collapseRoot() = %v, want %v", got, tt.want)
@@ -68,7 +78,7 @@ func TestBLTree_collapseRoot(t *testing.T) {
 
 func TestBLTree_cleanPage_full_page(t *testing.T) {
 	_ = os.Remove("data/bltree_clean_page.db")
-	mgr := NewBufMgr("data/bltree_clean_page.db", 15, 16*7)
+	mgr := newTestBufMgr(t, "data/bltree_clean_page.db", 15, 16*7)
 	bltree := NewBLTree(mgr)
 
 	f, err := os.OpenFile("testdata/page_for_clean", os.O_RDWR, 0666)
@@ -112,14 +122,14 @@ func TestBLTree_cleanPage_full_page(t *testing.T) {
 }
 
 func TestBLTree_insert_and_find(t *testing.T) {
-	mgr := NewBufMgr("data/bltree_insert_and_find.db", 13, 20)
+	mgr := newTestBufMgr(t, "data/bltree_insert_and_find.db", 13, 20)
 	bltree := NewBLTree(mgr)
 	if valLen, _, _ := bltree.findKey([]byte{1, 1, 1, 1}, BtId); valLen >= 0 {
 		t.Errorf("findKey() = %v, want %v", valLen, -1)
 	}
 
-	if err := bltree.insertKey([]byte{1, 1, 1, 1}, 0, [BtId]byte{0, 0, 0, 0, 0, 1}, true); err != BLTErrOk {
-		t.Errorf("insertKey() = %v, want %v", err, BLTErrOk)
+	if err := bltree.insertKey([]byte{1, 1, 1, 1}, 0, []byte{0, 0, 0, 0, 0, 1}, true); err != nil {
+		t.Errorf("insertKey() = %v, want %v", err, nil)
 	}
 
 	_, foundKey, _ := bltree.findKey([]byte{1, 1, 1, 1}, BtId)
@@ -130,7 +140,7 @@ func TestBLTree_insert_and_find(t *testing.T) {
 
 func TestBLTree_insert_and_find_many(t *testing.T) {
 	_ = os.Remove(`data/bltree_insert_and_find_many.db`)
-	mgr := NewBufMgr("data/bltree_insert_and_find_many.db", 13, 48)
+	mgr := newTestBufMgr(t, "data/bltree_insert_and_find_many.db", 13, 48)
 	bltree := NewBLTree(mgr)
 
 	num := uint64(160000)
@@ -138,8 +148,8 @@ func TestBLTree_insert_and_find_many(t *testing.T) {
 	for i := uint64(0); i < num; i++ {
 		bs := make([]byte, 8)
 		binary.BigEndian.PutUint64(bs, i)
-		if err := bltree.insertKey(bs, 0, [BtId]byte{}, true); err != BLTErrOk {
-			t.Errorf("insertKey() = %v, want %v", err, BLTErrOk)
+		if err := bltree.insertKey(bs, 0, make([]byte, BtId), true); err != nil {
+			t.Errorf("insertKey() = %v, want %v", err, nil)
 		}
 	}
 
@@ -154,7 +164,7 @@ func TestBLTree_insert_and_find_many(t *testing.T) {
 
 func TestBLTree_insert_and_find_concurrently(t *testing.T) {
 	_ = os.Remove(`data/insert_and_find_concurrently.db`)
-	mgr := NewBufMgr("data/insert_and_find_concurrently.db", 13, 16*7)
+	mgr := newTestBufMgr(t, "data/insert_and_find_concurrently.db", 13, 16*7)
 
 	keyTotal := 1600000
 
@@ -170,7 +180,7 @@ func TestBLTree_insert_and_find_concurrently(t *testing.T) {
 
 func TestBLTree_insert_and_find_concurrently_by_little_endian(t *testing.T) {
 	_ = os.Remove(`data/insert_and_find_concurrently_by_little_endian.db`)
-	mgr := NewBufMgr("data/insert_and_find_concurrently_by_little_endian.db", 13, 16*7)
+	mgr := newTestBufMgr(t, "data/insert_and_find_concurrently_by_little_endian.db", 13, 16*7)
 
 	keyTotal := 1600000
 
@@ -198,8 +208,8 @@ func insertAndFindConcurrently(t *testing.T, routineNum int, mgr *BufMgr, keys [
 				if i%routineNum != n {
 					continue
 				}
-				if err := bltree.insertKey(keys[i], 0, [BtId]byte{}, true); err != BLTErrOk {
-					t.Errorf("in goroutine%d insertKey() = %v, want %v", n, err, BLTErrOk)
+				if err := bltree.insertKey(keys[i], 0, make([]byte, BtId), true); err != nil {
+					t.Errorf("in goroutine%d insertKey() = %v, want %v", n, err, nil)
 				}
 
 				if _, foundKey, _ := bltree.findKey(keys[i], BtId); bytes.Compare(foundKey, keys[i]) != 0 {
@@ -238,17 +248,17 @@ func insertAndFindConcurrently(t *testing.T, routineNum int, mgr *BufMgr, keys [
 }
 
 func TestBLTree_delete(t *testing.T) {
-	mgr := NewBufMgr("data/bltree_delete.db", 13, 20)
+	mgr := newTestBufMgr(t, "data/bltree_delete.db", 13, 20)
 	bltree := NewBLTree(mgr)
 
 	key := []byte{1, 1, 1, 1}
 
-	if err := bltree.insertKey(key, 0, [BtId]byte{0, 0, 0, 0, 0, 1}, true); err != BLTErrOk {
-		t.Errorf("insertKey() = %v, want %v", err, BLTErrOk)
+	if err := bltree.insertKey(key, 0, []byte{0, 0, 0, 0, 0, 1}, true); err != nil {
+		t.Errorf("insertKey() = %v, want %v", err, nil)
 	}
 
-	if err := bltree.deleteKey(key, 0); err != BLTErrOk {
-		t.Errorf("deleteKey() = %v, want %v", err, BLTErrOk)
+	if err := bltree.deleteKey(key, 0); err != nil {
+		t.Errorf("deleteKey() = %v, want %v", err, nil)
 	}
 
 	if found, _, _ := bltree.findKey(key, BtId); found != -1 {
@@ -258,7 +268,7 @@ func TestBLTree_delete(t *testing.T) {
 
 func TestBLTree_deleteMany(t *testing.T) {
 	_ = os.Remove(`data/bltree_delete_many.db`)
-	mgr := NewBufMgr("data/bltree_delete_many.db", 13, 16*7)
+	mgr := newTestBufMgr(t, "data/bltree_delete_many.db", 13, 16*7)
 	bltree := NewBLTree(mgr)
 
 	keyTotal := 160000
@@ -271,12 +281,12 @@ func TestBLTree_deleteMany(t *testing.T) {
 	}
 
 	for i := range keys {
-		if err := bltree.insertKey(keys[i], 0, [BtId]byte{0, 0, 0, 0, 0, 0}, true); err != BLTErrOk {
-			t.Errorf("insertKey() = %v, want %v", err, BLTErrOk)
+		if err := bltree.insertKey(keys[i], 0, make([]byte, BtId), true); err != nil {
+			t.Errorf("insertKey() = %v, want %v", err, nil)
 		}
 		if i%2 == 0 {
-			if err := bltree.deleteKey(keys[i], 0); err != BLTErrOk {
-				t.Errorf("deleteKey() = %v, want %v", err, BLTErrOk)
+			if err := bltree.deleteKey(keys[i], 0); err != nil {
+				t.Errorf("deleteKey() = %v, want %v", err, nil)
 			}
 		}
 	}
@@ -296,7 +306,7 @@ func TestBLTree_deleteMany(t *testing.T) {
 
 func TestBLTree_deleteAll(t *testing.T) {
 	_ = os.Remove(`data/bltree_delete_all.db`)
-	mgr := NewBufMgr("data/bltree_delete_all.db", 13, 16*7)
+	mgr := newTestBufMgr(t, "data/bltree_delete_all.db", 13, 16*7)
 	bltree := NewBLTree(mgr)
 
 	keyTotal := 1600000
@@ -309,14 +319,14 @@ func TestBLTree_deleteAll(t *testing.T) {
 	}
 
 	for i := range keys {
-		if err := bltree.insertKey(keys[i], 0, [BtId]byte{0, 0, 0, 0, 0, 0}, true); err != BLTErrOk {
-			t.Errorf("insertKey() = %v, want %v", err, BLTErrOk)
+		if err := bltree.insertKey(keys[i], 0, make([]byte, BtId), true); err != nil {
+			t.Errorf("insertKey() = %v, want %v", err, nil)
 		}
 	}
 
 	for i := range keys {
-		if err := bltree.deleteKey(keys[i], 0); err != BLTErrOk {
-			t.Errorf("deleteKey() = %v, want %v", err, BLTErrOk)
+		if err := bltree.deleteKey(keys[i], 0); err != nil {
+			t.Errorf("deleteKey() = %v, want %v", err, nil)
 		}
 		if found, _, _ := bltree.findKey(keys[i], BtId); found != -1 {
 			t.Errorf("findKey() = %v, want %v, key %v", found, -1, keys[i])
@@ -326,7 +336,7 @@ func TestBLTree_deleteAll(t *testing.T) {
 
 func TestBLTree_deleteManyConcurrently(t *testing.T) {
 	_ = os.Remove("data/bltree_delete_many_concurrently.db")
-	mgr := NewBufMgr("data/bltree_delete_many_concurrently.db", 13, 16*7)
+	mgr := newTestBufMgr(t, "data/bltree_delete_many_concurrently.db", 13, 16*7)
 
 	keyTotal := 1600000
 	routineNum := 7
@@ -349,13 +359,13 @@ func TestBLTree_deleteManyConcurrently(t *testing.T) {
 				if i%routineNum != n {
 					continue
 				}
-				if err := bltree.insertKey(keys[i], 0, [BtId]byte{}, true); err != BLTErrOk {
-					t.Errorf("in goroutine%d insertKey() = %v, want %v", n, err, BLTErrOk)
+				if err := bltree.insertKey(keys[i], 0, make([]byte, BtId), true); err != nil {
+					t.Errorf("in goroutine%d insertKey() = %v, want %v", n, err, nil)
 				}
 
 				if i%2 == (n % 2) {
-					if err := bltree.deleteKey(keys[i], 0); err != BLTErrOk {
-						t.Errorf("deleteKey() = %v, want %v", err, BLTErrOk)
+					if err := bltree.deleteKey(keys[i], 0); err != nil {
+						t.Errorf("deleteKey() = %v, want %v", err, nil)
 					}
 				}
 
@@ -410,7 +420,7 @@ func TestBLTree_deleteManyConcurrently(t *testing.T) {
 
 func TestBLTree_restart(t *testing.T) {
 	_ = os.Remove(`data/bltree_restart.db`)
-	mgr := NewBufMgr("data/bltree_restart.db", 13, 48)
+	mgr := newTestBufMgr(t, "data/bltree_restart.db", 13, 48)
 	bltree := NewBLTree(mgr)
 
 	firstNum := uint64(1000)
@@ -418,13 +428,15 @@ func TestBLTree_restart(t *testing.T) {
 	for i := uint64(0); i <= firstNum; i++ {
 		bs := make([]byte, 8)
 		binary.BigEndian.PutUint64(bs, i)
-		if err := bltree.insertKey(bs, 0, [BtId]byte{}, true); err != BLTErrOk {
-			t.Errorf("insertKey() = %v, want %v", err, BLTErrOk)
+		if err := bltree.insertKey(bs, 0, make([]byte, BtId), true); err != nil {
+			t.Errorf("insertKey() = %v, want %v", err, nil)
 		}
 	}
 
-	mgr.Close()
-	mgr = NewBufMgr("data/bltree_restart.db", 15, 48)
+	if err := mgr.Close(); err != nil {
+		t.Fatalf("Close() err = %v", err)
+	}
+	mgr = newTestBufMgr(t, "data/bltree_restart.db", 15, 48)
 	bltree = NewBLTree(mgr)
 
 	secondNum := uint64(2000)
@@ -432,8 +444,8 @@ func TestBLTree_restart(t *testing.T) {
 	for i := firstNum; i <= secondNum; i++ {
 		bs := make([]byte, 8)
 		binary.BigEndian.PutUint64(bs, i)
-		if err := bltree.insertKey(bs, 0, [BtId]byte{}, true); err != BLTErrOk {
-			t.Errorf("insertKey() = %v, want %v", err, BLTErrOk)
+		if err := bltree.insertKey(bs, 0, make([]byte, BtId), true); err != nil {
+			t.Errorf("insertKey() = %v, want %v", err, nil)
 		}
 	}
 
@@ -445,3 +457,98 @@ func TestBLTree_restart(t *testing.T) {
 		}
 	}
 }
+
+func TestBLTree_restart_freeChainAndDups(t *testing.T) {
+	_ = os.Remove(`data/bltree_restart_free.db`)
+	mgr := newTestBufMgr(t, "data/bltree_restart_free.db", 13, 48)
+	bltree := NewBLTree(mgr)
+
+	key := []byte("overflow")
+	value := bytes.Repeat([]byte{0xa5}, 3*int(mgr.pageDataSize))
+	if err := bltree.insertKey(key, 0, value, true); err != nil {
+		t.Fatalf("insertKey() = %v, want %v", err, nil)
+	}
+	if err := bltree.deleteKey(key, 0); err != nil {
+		t.Fatalf("deleteKey() = %v, want %v", err, nil)
+	}
+	chain := GetID(mgr.pageZero.Chain())
+	if chain == 0 {
+		t.Fatalf("free chain is empty after deleteKey()")
+	}
+	allocRight := GetID(mgr.pageZero.AllocRight())
+	dup := bltree.newDup()
+
+	if err := mgr.Close(); err != nil {
+		t.Fatalf("Close() err = %v", err)
+	}
+	mgr = newTestBufMgr(t, "data/bltree_restart_free.db", 13, 48)
+	bltree = NewBLTree(mgr)
+
+	if got := GetID(mgr.pageZero.Chain()); got != chain {
+		t.Errorf("Chain() = %v, want %v", got, chain)
+	}
+	if got := bltree.newDup(); got != dup+1 {
+		t.Errorf("newDup() = %v, want %v", got, dup+1)
+	}
+
+	if err := bltree.insertKey(key, 0, value, true); err != nil {
+		t.Fatalf("insertKey() = %v, want %v", err, nil)
+	}
+	if got := GetID(mgr.pageZero.AllocRight()); got != allocRight {
+		t.Errorf("AllocRight() = %v, want %v (freed pages not reused)", got, allocRight)
+	}
+	if _, _, found := bltree.findKey(key, len(value)); !bytes.Equal(found, value) {
+		t.Errorf("findKey() value of %d bytes, want %d", len(found), len(value))
+	}
+}
+
+func TestBLTree_splitPage_appending(t *testing.T) {
+	perm := rand.New(rand.NewSource(1)).Perm(20000)
+	tests := []struct {
+		name    string
+		key     func(i int) int
+		minFill float64
+		maxFill float64
+	}{
+		// appends fill the pages left behind, up to their librarian slots
+		{name: "ascending", key: func(i int) int { return i }, minFill: 0.65, maxFill: 1},
+		{name: "descending", key: func(i int) int { return 20000 - i }, minFill: 0.4, maxFill: 0.55},
+		{name: "random", key: func(i int) int { return perm[i] }, minFill: 0.5, maxFill: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := Open("", Options{InMemory: true, PageBits: 12, PoolSize: 256})
+			if err != nil {
+				t.Fatalf("Open() err = %v", err)
+			}
+			defer tree.Close()
+
+			for i := 0; i < 20000; i++ {
+				key := []byte(fmt.Sprintf("key%05d", tt.key(i)))
+				if err := tree.Put(key, key); err != nil {
+					t.Fatalf("Put() err = %v", err)
+				}
+			}
+
+			report, err := tree.Check()
+			if err != nil {
+				t.Fatalf("Check() err = %v", err)
+			}
+			if err := report.Err(); err != nil {
+				t.Fatalf("Check() violations %v", report.Violations)
+			}
+			if report.Keys != 20000 {
+				t.Errorf("Check() Keys = %d, want %d", report.Keys, 20000)
+			}
+
+			s, err := tree.Stats()
+			if err != nil {
+				t.Fatalf("Stats() err = %v", err)
+			}
+			if s.FillFactor < tt.minFill || s.FillFactor > tt.maxFill {
+				t.Errorf("Stats() FillFactor = %.2f, want between %v and %v", s.FillFactor, tt.minFill, tt.maxFill)
+			}
+		})
+	}
+}
@@ -1,25 +1,31 @@
-package main
+package blinktree
 
 import (
 	"bytes"
 	"encoding/binary"
-	"io"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
 	"os"
+	"sync"
 	"sync/atomic"
-	"syscall"
 )
 
 type (
+	// PageZero is the allocation page. The next page_no is kept in the
+	// right ptr of its header, followed in the data area by the head of
+	// the free page_nos chain and the global duplicate key unique id.
 	PageZero struct {
-		alloc []byte      // next page_no in right ptr
-		dups  uint64      // global duplicate key unique id
-		chain [BtId]uint8 // head of free page_nos chain
+		alloc []byte // page zero as written by the last commit
 	}
 	BufMgr struct {
-		pageSize     uint32 // page size
-		pageBits     uint8  // page size in bits
-		pageDataSize uint32 // page data size
-		idx          *os.File
+		pageSize     uint32    // page size
+		pageBits     uint8     // page size in bits
+		pageDataSize uint32    // page data size
+		idx          PageStore // btree file
+		wal          *wal      // write-ahead log of pages not yet checkpointed
+		readOnly     bool      // opened with BtRO, pages are never written
 
 		pageZero      PageZero
 		lock          SpinLatch   // allocation area lite latch
@@ -32,10 +38,43 @@ type (
 		latchSets     []LatchSet  // mapped latch set from buffer pool
 		pagePool      []Page      // mapped to the buffer pool pages
 
-		err BLTErr // last error
+		snapLock  sync.Mutex             // guards snapshots
+		snapshots map[*snapshot]struct{} // open snapshots keeping page preimages
+		snapCount int32                  // number of open snapshots
+
+		stats poolStats
+	}
+
+	// poolStats counts the buffer pool events reported by Tree.Stats
+	poolStats struct {
+		hits        atomic.Uint64 // pins finding the page in the pool
+		misses      atomic.Uint64 // pins reading the page from the log or the btree file
+		evictions   atomic.Uint64 // pool entries taken from another page
+		reads       atomic.Uint64 // pages read from the log or the btree file
+		writes      atomic.Uint64 // pages appended to the log
+		checkpoints atomic.Uint64 // checkpoints copying the log to the btree file
+		latchWaits  atomic.Uint64 // page locks not granted at once
 	}
 )
 
+const (
+	FileMagic     = 0x544c4e42 // magic number stored in page zero
+	FormatVersion = 1          // version of the file format
+)
+
+// layout of the page zero data area
+const (
+	zeroChain   = PageHeaderSize   // head of free page_nos chain
+	zeroDups    = zeroChain + BtId // global duplicate key unique id
+	zeroMagic   = zeroDups + 8     // FileMagic
+	zeroVersion = zeroMagic + 4    // FormatVersion
+)
+
+// offset of the checksum in the page header
+const sumOffset = PageHeaderSize - 4
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
 func (z *PageZero) AllocRight() *[BtId]byte {
 	rightStart := 4*4 + 1 + 1 + 1 + 1
 	return (*[6]byte)(z.alloc[rightStart : rightStart+6])
@@ -45,8 +84,100 @@ func (z *PageZero) SetAllocRight(pageNo uid) {
 	PutID(z.AllocRight(), pageNo)
 }
 
-// NewBufMgr creates a new buffer manager
-func NewBufMgr(name string, bits uint8, nodeMax uint) *BufMgr {
+func (z *PageZero) Chain() *[BtId]byte {
+	return (*[BtId]byte)(z.alloc[zeroChain : zeroChain+BtId])
+}
+
+func (z *PageZero) SetChain(pageNo uid) {
+	PutID(z.Chain(), pageNo)
+}
+
+func (z *PageZero) Dups() uint64 {
+	return binary.LittleEndian.Uint64(z.alloc[zeroDups:])
+}
+
+func (z *PageZero) SetDups(dups uint64) {
+	binary.LittleEndian.PutUint64(z.alloc[zeroDups:], dups)
+}
+
+// checkVersion verifies the magic number and format version of page zero
+func checkVersion(pageBytes []byte) error {
+	if magic := binary.LittleEndian.Uint32(pageBytes[zeroMagic:]); magic != FileMagic {
+		return fmt.Errorf("%w: bad magic number %#x", ErrVersion, magic)
+	}
+	if version := binary.LittleEndian.Uint32(pageBytes[zeroVersion:]); version != FormatVersion {
+		return fmt.Errorf("%w: %d, want %d", ErrVersion, version, FormatVersion)
+	}
+	return nil
+}
+
+// pageSum returns the checksum of an encoded page, skipping its checksum field
+func pageSum(pageBytes []byte) uint32 {
+	sum := crc32.Checksum(pageBytes[:sumOffset], crcTable)
+	return crc32.Update(sum, crcTable, pageBytes[PageHeaderSize:])
+}
+
+// setPageSum stores the checksum of an encoded page in its header
+func setPageSum(pageBytes []byte) {
+	binary.LittleEndian.PutUint32(pageBytes[sumOffset:], pageSum(pageBytes))
+}
+
+// NewBufMgr creates a new buffer manager for the btree file name
+// opened in mode BtRW or BtRO. A file opened read-only must exist.
+func NewBufMgr(name string, mode int, bits uint8, nodeMax uint) (*BufMgr, error) {
+	readOnly := mode == BtRO
+	flag := os.O_RDWR | os.O_CREATE
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	idx, err := os.OpenFile(name, flag, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("blinktree: unable to open btree file: %w", err)
+	}
+
+	// another buffer manager on the same file would keep its own
+	// pool and page zero, only readers may share it
+	if err := lockFile(idx, !readOnly); err != nil {
+		_ = idx.Close()
+		return nil, err
+	}
+
+	// a read-only tree without a log reads the btree file alone
+	var log PageStore
+	if f, err := os.OpenFile(name+"-wal", flag, 0666); err == nil {
+		log = NewFileStore(f)
+	} else if !readOnly || !errors.Is(err, fs.ErrNotExist) {
+		_ = idx.Close()
+		return nil, fmt.Errorf("blinktree: unable to open write-ahead log: %w", err)
+	}
+
+	return NewStoreBufMgr(NewFileStore(idx), log, mode, bits, nodeMax)
+}
+
+// NewMemBufMgr creates a new buffer manager without a backing file.
+// Pages evicted from the buffer pool are kept in memory, and so is the
+// write-ahead log: it is still needed to roll back to the last commit.
+func NewMemBufMgr(bits uint8, nodeMax uint) (*BufMgr, error) {
+	return NewStoreBufMgr(NewMemStore(), NewMemStore(), BtRW, bits, nodeMax)
+}
+
+// NewStoreBufMgr creates a new buffer manager on the btree pages of
+// store, logging updates to the write-ahead log kept in log. Both
+// stores are closed with the buffer manager, or on error.
+func NewStoreBufMgr(store, log PageStore, mode int, bits uint8, nodeMax uint) (*BufMgr, error) {
+	mgr, err := newBufMgr(store, log, mode == BtRO, bits, nodeMax)
+	if err != nil {
+		_ = store.Close()
+		if log != nil {
+			_ = log.Close()
+		}
+		return nil, err
+	}
+
+	return mgr, nil
+}
+
+func newBufMgr(store, log PageStore, readOnly bool, bits uint8, nodeMax uint) (*BufMgr, error) {
 	initit := true
 
 	// determine sanity of page size
@@ -58,35 +189,31 @@ func NewBufMgr(name string, bits uint8, nodeMax uint) *BufMgr {
 
 	// determine sanity of buffer pool
 	if nodeMax < 16 {
-		errPrintf("Buffer pool too small: %d\n", nodeMax)
-		return nil
+		return nil, fmt.Errorf("blinktree: buffer pool too small: %d", nodeMax)
 	}
 
 	var err error
 
-	mgr := BufMgr{}
-	mgr.idx, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
-	if err != nil {
-		errPrintf("Unable to open btree file: %v\n", err)
-		return nil
-	}
+	mgr := BufMgr{idx: store, readOnly: readOnly}
 
 	// read minimum page size to get root info
 	//  to support raw disk partition files
 	//  check if bits == 0 on the disk.
-	if size, err := mgr.idx.Seek(0, io.SeekEnd); size > 0 && err == nil {
+	if size, err := mgr.idx.Size(); size >= BtMinPage && err == nil {
 		pageBytes := make([]byte, BtMinPage)
 
-		if n, err := mgr.idx.ReadAt(pageBytes, 0); err == nil && n == BtMinPage {
+		if err := mgr.idx.ReadPage(0, pageBytes); err == nil {
 			var page Page
 
 			if err := binary.Read(bytes.NewReader(pageBytes), binary.LittleEndian, &page.PageHeader); err != nil {
-				errPrintf("Unable to read btree file: %v\n", err)
-				return nil
+				return nil, fmt.Errorf("%w: page zero header: %w", ErrCorrupt, err)
 			}
 			page.Data = pageBytes[PageHeaderSize:]
 
 			if page.Bits > 0 {
+				if err := checkVersion(pageBytes); err != nil {
+					return nil, err
+				}
 				bits = page.Bits
 				initit = false
 			}
@@ -104,19 +231,13 @@ func NewBufMgr(name string, bits uint8, nodeMax uint) *BufMgr {
 
 	mgr.latchTotal = nodeMax
 
+	if initit && mgr.readOnly {
+		return nil, fmt.Errorf("%w: btree file is not initialized", ErrReadOnly)
+	}
+
 	if initit {
 		alloc := NewPage(mgr.pageDataSize)
 		alloc.Bits = mgr.pageBits
-		PutID(&alloc.Right, MinLvl+1)
-
-		if mgr.writePage(alloc, 0) != BLTErrOk {
-			errPrintf("Unable to create btree page zero\n")
-			mgr.Close()
-			return nil
-		}
-
-		alloc = NewPage(mgr.pageDataSize)
-		alloc.Bits = mgr.pageBits
 
 		for lvl := MinLvl - 1; lvl >= 0; lvl-- {
 			z := uint32(1) // size of BLTVal
@@ -140,104 +261,265 @@ func NewBufMgr(name string, bits uint8, nodeMax uint) *BufMgr {
 			alloc.Cnt = 1
 			alloc.Act = 1
 
-			if err := mgr.writePage(alloc, uid(MinLvl-lvl)); err != BLTErrOk {
-				errPrintf("Unable to create btree page zero\n")
-				return nil
+			if err := mgr.writePage(alloc, uid(MinLvl-lvl)); err != nil {
+				return nil, err
 			}
 		}
 
+		// page zero is written last, it marks the file as initialized
+		alloc = NewPage(mgr.pageDataSize)
+		alloc.Bits = mgr.pageBits
+		PutID(&alloc.Right, MinLvl+1)
+		binary.LittleEndian.PutUint32(alloc.Data[zeroMagic-PageHeaderSize:], FileMagic)
+		binary.LittleEndian.PutUint32(alloc.Data[zeroVersion-PageHeaderSize:], FormatVersion)
+
+		if err := mgr.writePage(alloc, 0); err != nil {
+			return nil, err
+		}
+		if err := mgr.idx.Sync(); err != nil {
+			return nil, fmt.Errorf("%w: sync btree file: %w", ErrWrite, err)
+		}
 	}
 
-	flag := syscall.PROT_READ | syscall.PROT_WRITE
-	mgr.pageZero.alloc, err = syscall.Mmap(int(mgr.idx.Fd()), 0, int(mgr.pageSize), flag, syscall.MAP_SHARED)
+	// a log left next to a new btree file belongs to another tree.
+	// A read-only tree leaves the log in place and reads through it.
+	mgr.wal, err = newWAL(log, mgr.pageSize, mgr.readOnly)
+	if err == nil && initit {
+		err = mgr.wal.reset()
+	}
+	if err == nil && !mgr.readOnly {
+		err = mgr.checkpoint()
+	}
 	if err != nil {
-		errPrintf("Unable to mmap btree page zero: %v\n", err)
-		mgr.Close()
-		return nil
+		return nil, err
 	}
 
-	// comment out because of panic
-	//if err := syscall.Mlock(mgr.pageZero); err != nil {
-	//	log.Panicf("Unable to mlock btree page zero: %v", err)
-	//}
+	mgr.pageZero.alloc = make([]byte, mgr.pageSize)
+	err = mgr.readPageZero()
+	if err == nil && pageSum(mgr.pageZero.alloc) != binary.LittleEndian.Uint32(mgr.pageZero.alloc[sumOffset:]) {
+		err = fmt.Errorf("%w: page 0: %w", ErrCorrupt, ErrChecksum)
+	}
+	if err == nil {
+		err = checkVersion(mgr.pageZero.alloc)
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	mgr.hashTable = make([]HashEntry, mgr.latchHash)
 	mgr.latchSets = make([]LatchSet, mgr.latchTotal)
 	mgr.pagePool = make([]Page, mgr.latchTotal)
 
-	return &mgr
+	return &mgr, nil
 }
 
-func (mgr *BufMgr) readPage(page *Page, pageNo uid) BLTErr {
-	off := pageNo << mgr.pageBits
-
+// readPage reads a page from the write-ahead log,
+// or from its permanent location in BLTree file
+func (mgr *BufMgr) readPage(page *Page, pageNo uid) error {
+	mgr.stats.reads.Add(1)
 	pageBytes := make([]byte, mgr.pageSize)
-	if n, err := mgr.idx.ReadAt(pageBytes, int64(off)); err != nil || n < int(mgr.pageSize) {
-		errPrintf("Unable to read page. Because of err: %v or n: %d\n", err, n)
-		return BLTErrRead
+	if found, err := mgr.wal.read(pageNo, pageBytes); err != nil {
+		return err
+	} else if found {
+		return decodePage(page, pageBytes, pageNo)
+	}
+	if err := mgr.idx.ReadPage(uint64(pageNo), pageBytes); err != nil {
+		return fmt.Errorf("%w %d: %w", ErrRead, pageNo, err)
 	}
 
+	return decodePage(page, pageBytes, pageNo)
+}
+
+func decodePage(page *Page, pageBytes []byte, pageNo uid) error {
+	if pageSum(pageBytes) != binary.LittleEndian.Uint32(pageBytes[sumOffset:]) {
+		return fmt.Errorf("%w: page %d: %w", ErrCorrupt, pageNo, ErrChecksum)
+	}
 	if err := binary.Read(bytes.NewReader(pageBytes), binary.LittleEndian, &page.PageHeader); err != nil {
-		errPrintf("Unable to read page header as bytes: %v\n", err)
-		return BLTErrRead
+		return fmt.Errorf("%w: page %d header: %w", ErrCorrupt, pageNo, err)
 	}
 	page.Data = pageBytes[PageHeaderSize:]
 
-	return BLTErrOk
+	return nil
 }
 
-// writePage writes a page to permanent location in BLTree file,
-// and clear the dirty bit (← clear していない...)
-func (mgr *BufMgr) writePage(page *Page, pageNo uid) BLTErr {
-	off := pageNo << mgr.pageBits
-	// write page to disk as []byte
+// encodePage returns the page as stored on disk
+func (mgr *BufMgr) encodePage(page *Page, pageNo uid) ([]byte, error) {
 	buf := bytes.NewBuffer(make([]byte, 0, mgr.pageSize))
 	if err := binary.Write(buf, binary.LittleEndian, page.PageHeader); err != nil {
-		errPrintf("Unable to output page header as bytes: %v\n", err)
-		return BLTErrWrite
-	}
-	if _, err := buf.Write(page.Data); err != nil {
-		errPrintf("Unable to output page data: %v\n", err)
-		return BLTErrWrite
+		return nil, fmt.Errorf("%w %d: %w", ErrWrite, pageNo, err)
 	}
+	buf.Write(page.Data)
 	if buf.Len() < int(mgr.pageSize) {
 		buf.Write(make([]byte, int(mgr.pageSize)-buf.Len()))
 	}
-	if _, err := mgr.idx.WriteAt(buf.Bytes(), int64(off)); err != nil {
-		errPrintf("Unable to write btree file: %v\n", err)
-		return BLTErrWrite
+
+	pageBytes := buf.Bytes()
+	setPageSum(pageBytes)
+	return pageBytes, nil
+}
+
+// writePage writes a page to permanent location in BLTree file
+func (mgr *BufMgr) writePage(page *Page, pageNo uid) error {
+	// write page to disk as []byte
+	pageBytes, err := mgr.encodePage(page, pageNo)
+	if err != nil {
+		return err
+	}
+	if err := mgr.idx.WritePage(uint64(pageNo), pageBytes); err != nil {
+		return fmt.Errorf("%w %d: %w", ErrWrite, pageNo, err)
 	}
 
-	return BLTErrOk
+	return nil
 }
 
-// Close
+// logPage appends a page to the write-ahead log
+func (mgr *BufMgr) logPage(page *Page, pageNo uid) error {
+	pageBytes, err := mgr.encodePage(page, pageNo)
+	if err != nil {
+		return err
+	}
+
+	if err := mgr.wal.append(pageNo, pageBytes, false); err != nil {
+		return err
+	}
+	mgr.stats.writes.Add(1)
+
+	return nil
+}
+
+// Commit
 //
-// flush dirty pool pages to the btree and close the btree file
-func (mgr *BufMgr) Close() {
-	num := 0
-	// flush dirty pool pages to the btree
+// append the dirty pool pages and page zero to the write-ahead log
+// and optionally fsync it. No update may be in progress during a commit.
+func (mgr *BufMgr) Commit(fsync bool) error {
+	dirty := false
 	var slot uint32
 	for slot = 1; slot <= mgr.latchDeployed; slot++ {
 		page := &mgr.pagePool[slot]
 		latch := &mgr.latchSets[slot]
 
 		if latch.dirty {
-			mgr.writePage(page, latch.pageNo)
+			if err := mgr.logPage(page, latch.pageNo); err != nil {
+				return err
+			}
 			latch.dirty = false
-			num++
+			dirty = true
+		}
+	}
+
+	if !dirty && !mgr.wal.pending() {
+		if fsync {
+			return mgr.wal.sync()
+		}
+		return nil
+	}
+
+	setPageSum(mgr.pageZero.alloc)
+	if err := mgr.wal.append(0, mgr.pageZero.alloc, true); err != nil {
+		return err
+	}
+	mgr.stats.writes.Add(1)
+	if fsync {
+		if err := mgr.wal.sync(); err != nil {
+			return err
 		}
 	}
 
-	errPrintf("%d buffer pool pages flushed\n", num)
+	if mgr.wal.frames() >= walCheckpointFrames {
+		return mgr.checkpoint()
+	}
+	return nil
+}
 
-	if err := syscall.Munmap(mgr.pageZero.alloc); err != nil {
-		errPrintf("Unable to munmap btree page zero: %v\n", err)
+// Rollback
+//
+// discard the updates made since the last commit by emptying the
+// buffer pool, dropping the uncommitted frames of the write-ahead log
+// and reloading page zero. No other access may be in progress.
+func (mgr *BufMgr) Rollback() error {
+	mgr.wal.rollback()
+
+	for idx := range mgr.hashTable {
+		mgr.hashTable[idx].slot = 0
+	}
+	for slot := range mgr.latchSets {
+		mgr.latchSets[slot] = LatchSet{}
+		mgr.pagePool[slot] = Page{}
+	}
+	mgr.latchDeployed = 0
+	mgr.latchVictim = 0
+
+	return mgr.readPageZero()
+}
+
+// readPageZero reads the last committed page zero
+// from the write-ahead log or the btree file
+func (mgr *BufMgr) readPageZero() error {
+	alloc := make([]byte, len(mgr.pageZero.alloc))
+	if found, err := mgr.wal.read(0, alloc); err != nil {
+		return err
+	} else if !found {
+		if err := mgr.idx.ReadPage(0, alloc); err != nil {
+			return fmt.Errorf("%w 0: %w", ErrRead, err)
+		}
+	}
+
+	// the counters are read without holding the tree
+	mgr.lock.SpinWriteLock()
+	copy(mgr.pageZero.alloc, alloc)
+	mgr.lock.SpinReleaseWrite()
+	return nil
+}
+
+// checkpoint
+//
+// copy the committed pages of the write-ahead log to the btree file,
+// sync it and reset the log
+func (mgr *BufMgr) checkpoint() error {
+	pageBytes := make([]byte, mgr.pageSize)
+	for pageNo := range mgr.wal.index {
+		if _, err := mgr.wal.read(pageNo, pageBytes); err != nil {
+			return err
+		}
+		if err := mgr.idx.WritePage(uint64(pageNo), pageBytes); err != nil {
+			return fmt.Errorf("%w %d: %w", ErrWrite, pageNo, err)
+		}
+	}
+
+	if len(mgr.wal.index) > 0 {
+		if err := mgr.idx.Sync(); err != nil {
+			return fmt.Errorf("%w: sync btree file: %w", ErrWrite, err)
+		}
+		mgr.stats.checkpoints.Add(1)
+	}
+
+	return mgr.wal.reset()
+}
+
+// Close
+//
+// commit dirty pool pages, checkpoint the log and close the btree file
+func (mgr *BufMgr) Close() error {
+	var errs []error
+	if !mgr.readOnly {
+		err := mgr.Commit(true)
+		if err == nil {
+			err = mgr.checkpoint()
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := mgr.wal.close(); err != nil {
+		errs = append(errs, err)
 	}
 
 	if err := mgr.idx.Close(); err != nil {
-		errPrintf("Unable to close btree file: %v\n", err)
+		errs = append(errs, fmt.Errorf("blinktree: unable to close btree file: %w", err))
 	}
+
+	return errors.Join(errs...)
 }
 
 // poolAudit
@@ -269,7 +551,7 @@ func (mgr *BufMgr) poolAudit() {
 }
 
 // latchLink
-func (mgr *BufMgr) latchLink(hashIdx uint, slot uint, pageNo uid, loadIt bool, reads *uint) BLTErr {
+func (mgr *BufMgr) latchLink(hashIdx uint, slot uint, pageNo uid, loadIt bool, reads *uint) error {
 	page := &mgr.pagePool[slot]
 	latch := &mgr.latchSets[slot]
 
@@ -283,22 +565,23 @@ func (mgr *BufMgr) latchLink(hashIdx uint, slot uint, pageNo uid, loadIt bool, r
 	}
 
 	mgr.hashTable[hashIdx].slot = slot
-	latch.atomicID = 0
 	latch.pageNo = pageNo
 	latch.entry = slot
-	latch.split = 0
 	latch.prev = 0
 	latch.pin = 1
+	latch.failed = false
 
 	if loadIt {
-		if mgr.err = mgr.readPage(page, pageNo); mgr.err != BLTErrOk {
-			return mgr.err
+		// the entry stays linked unpinned, the next pin retries the read
+		if err := mgr.readPage(page, pageNo); err != nil {
+			latch.pin = 0
+			latch.failed = true
+			return err
 		}
 		*reads++
 	}
 
-	mgr.err = BLTErrOk
-	return mgr.err
+	return nil
 }
 
 // MapPage maps a page from the buffer pool
@@ -307,7 +590,7 @@ func (mgr *BufMgr) MapPage(latch *LatchSet) *Page {
 }
 
 // PinLatch pins a page in the buffer pool
-func (mgr *BufMgr) PinLatch(pageNo uid, loadIt bool, reads *uint, writes *uint) *LatchSet {
+func (mgr *BufMgr) PinLatch(pageNo uid, loadIt bool, reads *uint, writes *uint) (*LatchSet, error) {
 	hashIdx := uint(pageNo) % mgr.latchHash
 
 	// try to find our entry
@@ -325,10 +608,22 @@ func (mgr *BufMgr) PinLatch(pageNo uid, loadIt bool, reads *uint, writes *uint)
 
 	// found our entry increment clock
 	if slot > 0 {
+		mgr.stats.hits.Add(1)
 		latch := &mgr.latchSets[slot]
+		if latch.failed && loadIt {
+			if err := mgr.readPage(&mgr.pagePool[slot], pageNo); err != nil {
+				return nil, err
+			}
+			*reads++
+		}
+		latch.failed = false
 		atomic.AddUint32(&latch.pin, 1)
 
-		return latch
+		return latch, nil
+	}
+
+	if loadIt {
+		mgr.stats.misses.Add(1)
 	}
 
 	// see if there are any unused pool entries
@@ -336,11 +631,11 @@ func (mgr *BufMgr) PinLatch(pageNo uid, loadIt bool, reads *uint, writes *uint)
 	slot = uint(atomic.AddUint32(&mgr.latchDeployed, 1))
 	if slot < mgr.latchTotal {
 		latch := &mgr.latchSets[slot]
-		if mgr.latchLink(hashIdx, slot, pageNo, loadIt, reads) != BLTErrOk {
-			return nil
+		if err := mgr.latchLink(hashIdx, slot, pageNo, loadIt, reads); err != nil {
+			return nil, err
 		}
 
-		return latch
+		return latch, nil
 	}
 
 	atomic.AddUint32(&mgr.latchDeployed, DECREMENT)
@@ -358,12 +653,15 @@ func (mgr *BufMgr) PinLatch(pageNo uid, loadIt bool, reads *uint, writes *uint)
 		latch := &mgr.latchSets[slot]
 		idx := uint(latch.pageNo) % mgr.latchHash
 
-		// see we are on same chain as hashIdx
-		if idx == hashIdx {
+		// the chain of hashIdx is already write locked by us
+		sameChain := idx == hashIdx
+		if !sameChain && !mgr.hashTable[idx].latch.SpinWriteTry() {
 			continue
 		}
-		if !mgr.hashTable[idx].latch.SpinWriteTry() {
-			continue
+		releaseChain := func() {
+			if !sameChain {
+				mgr.hashTable[idx].latch.SpinReleaseWrite()
+			}
 		}
 
 		// skip this slot if it is pinned or the CLOCK bit is set
@@ -371,7 +669,7 @@ func (mgr *BufMgr) PinLatch(pageNo uid, loadIt bool, reads *uint, writes *uint)
 			if latch.pin&ClockBit > 0 {
 				FetchAndAndUint32(&latch.pin, ^ClockBit)
 			}
-			mgr.hashTable[idx].latch.SpinReleaseWrite()
+			releaseChain()
 			continue
 		}
 
@@ -379,8 +677,9 @@ func (mgr *BufMgr) PinLatch(pageNo uid, loadIt bool, reads *uint, writes *uint)
 		page := mgr.pagePool[slot]
 
 		if latch.dirty {
-			if err := mgr.writePage(&page, latch.pageNo); err != BLTErrOk {
-				return nil
+			if err := mgr.logPage(&page, latch.pageNo); err != nil {
+				releaseChain()
+				return nil, err
 			} else {
 				latch.dirty = false
 				*writes++
@@ -398,13 +697,14 @@ func (mgr *BufMgr) PinLatch(pageNo uid, loadIt bool, reads *uint, writes *uint)
 			mgr.latchSets[latch.next].prev = latch.prev
 		}
 
-		if mgr.latchLink(hashIdx, slot, pageNo, loadIt, reads) != BLTErrOk {
-			mgr.hashTable[idx].latch.SpinReleaseWrite()
-			return nil
+		mgr.stats.evictions.Add(1)
+		if err := mgr.latchLink(hashIdx, slot, pageNo, loadIt, reads); err != nil {
+			releaseChain()
+			return nil, err
 		}
-		mgr.hashTable[idx].latch.SpinReleaseWrite()
+		releaseChain()
 
-		return latch
+		return latch, nil
 	}
 }
 
@@ -418,28 +718,26 @@ func (mgr *BufMgr) UnpinLatch(latch *LatchSet) {
 
 // NewPage allocate a new page
 // returns the page with latched but unlocked
-func (mgr *BufMgr) NewPage(set *PageSet, contents *Page, reads *uint, writes *uint) BLTErr {
+func (mgr *BufMgr) NewPage(set *PageSet, contents *Page, reads *uint, writes *uint) error {
 	// lock allocation page
 	mgr.lock.SpinWriteLock()
 
 	// use empty chain first, else allocate empty page
-	pageNo := GetID(&mgr.pageZero.chain)
+	pageNo := GetID(mgr.pageZero.Chain())
 	if pageNo > 0 {
-		set.latch = mgr.PinLatch(pageNo, true, reads, writes)
-		if set.latch != nil {
-			set.page = mgr.MapPage(set.latch)
-		} else {
-			mgr.err = BLTErrStruct
-			return mgr.err
+		var err error
+		if set.latch, err = mgr.PinLatch(pageNo, true, reads, writes); err != nil {
+			mgr.lock.SpinReleaseWrite()
+			return err
 		}
+		set.page = mgr.MapPage(set.latch)
 
-		PutID(&mgr.pageZero.chain, GetID(&set.page.Right))
+		mgr.pageZero.SetChain(GetID(&set.page.Right))
 		mgr.lock.SpinReleaseWrite()
 		MemCpyPage(set.page, contents)
 
 		set.latch.dirty = true
-		mgr.err = BLTErrOk
-		return mgr.err
+		return nil
 	}
 
 	pageNo = GetID(mgr.pageZero.AllocRight())
@@ -449,23 +747,25 @@ func (mgr *BufMgr) NewPage(set *PageSet, contents *Page, reads *uint, writes *ui
 	mgr.lock.SpinReleaseWrite()
 
 	// don't load cache from btree page
-	set.latch = mgr.PinLatch(pageNo, false, reads, writes)
-	if set.latch != nil {
-		set.page = mgr.MapPage(set.latch)
-	} else {
-		mgr.err = BLTErrStruct
-		return mgr.err
+	var err error
+	if set.latch, err = mgr.PinLatch(pageNo, false, reads, writes); err != nil {
+		return err
 	}
+	set.page = mgr.MapPage(set.latch)
 
 	set.page.Data = make([]byte, mgr.pageDataSize)
 	MemCpyPage(set.page, contents)
 	set.latch.dirty = true
-	mgr.err = BLTErrOk
-	return mgr.err
+	return nil
 }
 
 // LoadPage find and load page at given level for given key leave page read or write locked as requested
-func (mgr *BufMgr) LoadPage(set *PageSet, key []byte, lvl uint8, lock BLTLockMode, reads *uint, writes *uint) uint32 {
+func (mgr *BufMgr) LoadPage(set *PageSet, key []byte, lvl uint8, lock BLTLockMode, reads *uint, writes *uint) (uint32, error) {
+	return mgr.loadPage(set, key, lvl, lock, nil, reads, writes)
+}
+
+// loadPage is LoadPage reading the pages as of snap when it is not nil
+func (mgr *BufMgr) loadPage(set *PageSet, key []byte, lvl uint8, lock BLTLockMode, snap *snapshot, reads *uint, writes *uint) (uint32, error) {
 	pageNo := RootPage
 	prevPage := uid(0)
 	drill := uint8(0xff)
@@ -484,10 +784,15 @@ func (mgr *BufMgr) LoadPage(set *PageSet, key []byte, lvl uint8, lock BLTLockMod
 			mode = LockRead
 		}
 
-		set.latch = mgr.PinLatch(pageNo, true, reads, writes)
-		if set.latch == nil {
-			return 0
+		latch, err := mgr.PinLatch(pageNo, true, reads, writes)
+		if err != nil {
+			if prevPage > 0 {
+				mgr.UnlockPage(prevMode, prevLatch)
+				mgr.UnpinLatch(prevLatch)
+			}
+			return 0, err
 		}
+		set.latch = latch
 
 		// obtain access lock using lock chaining with Access mode
 		if pageNo > RootPage {
@@ -503,27 +808,17 @@ func (mgr *BufMgr) LoadPage(set *PageSet, key []byte, lvl uint8, lock BLTLockMod
 			prevPage = uid(0)
 		}
 
-		// skip Atomic lock on leaf page if already held
-		// Note: not supported in this golang implementation
-		//if (!drill) {
-		//	if (mode & LockAtomic) {
-		//		if (pthread_equal( set->latch->atomictid, pthread_self() )) {
-		//			mode &= ~LockAtomic;
-		//		}
-		//	}
-		//}
-
 		// obtain mode lock using lock chaining through AccessLock
 		mgr.LockPage(mode, set.latch)
-
-		// Note: not supported in this golang implementation
-		//if (mode & LockAtomic) {
-		//	set->latch->atomictid = pthread_self();
-		//}
+		set.page = snap.view(set.latch, set.page)
 
 		if set.page.Free {
-			mgr.err = BLTErrStruct
-			return 0
+			if pageNo > RootPage {
+				mgr.UnlockPage(LockAccess, set.latch)
+			}
+			mgr.UnlockPage(mode, set.latch)
+			mgr.UnpinLatch(set.latch)
+			return 0, fmt.Errorf("%w: page %d on free chain", ErrCorrupt, pageNo)
 		}
 
 		if pageNo > RootPage {
@@ -533,8 +828,9 @@ func (mgr *BufMgr) LoadPage(set *PageSet, key []byte, lvl uint8, lock BLTLockMod
 		// re-read and re-lock root after determining actual level of root
 		if set.page.Lvl != drill {
 			if set.latch.pageNo != RootPage {
-				mgr.err = BLTErrStruct
-				return 0
+				mgr.UnlockPage(mode, set.latch)
+				mgr.UnpinLatch(set.latch)
+				return 0, fmt.Errorf("%w: page %d has level %d, want %d", ErrCorrupt, pageNo, set.page.Lvl, drill)
 			}
 
 			drill = set.page.Lvl
@@ -559,7 +855,7 @@ func (mgr *BufMgr) LoadPage(set *PageSet, key []byte, lvl uint8, lock BLTLockMod
 		slot = set.page.FindSlot(key)
 		if slot > 0 {
 			if drill == lvl {
-				return slot
+				return slot, nil
 			}
 
 			for set.page.Dead(slot) {
@@ -581,8 +877,11 @@ func (mgr *BufMgr) LoadPage(set *PageSet, key []byte, lvl uint8, lock BLTLockMod
 	}
 
 	// return error on end of right chain
-	mgr.err = BLTErrStruct
-	return 0
+	if prevPage > 0 {
+		mgr.UnlockPage(prevMode, prevLatch)
+		mgr.UnpinLatch(prevLatch)
+	}
+	return 0, fmt.Errorf("%w: end of right chain", ErrCorrupt)
 }
 
 // FreePage
@@ -595,8 +894,8 @@ func (mgr *BufMgr) FreePage(set *PageSet) {
 	mgr.lock.SpinWriteLock()
 
 	// store chain
-	set.page.Right = mgr.pageZero.chain
-	PutID(&mgr.pageZero.chain, set.latch.pageNo)
+	set.page.Right = *mgr.pageZero.Chain()
+	mgr.pageZero.SetChain(set.latch.pageNo)
 	set.latch.dirty = true
 	set.page.Free = true
 
@@ -609,22 +908,61 @@ func (mgr *BufMgr) FreePage(set *PageSet) {
 	mgr.lock.SpinReleaseWrite()
 }
 
+// NewDup
+//
+// return the next global duplicate key unique id
+func (mgr *BufMgr) NewDup() uid {
+	mgr.lock.SpinWriteLock()
+	defer mgr.lock.SpinReleaseWrite()
+
+	dups := mgr.pageZero.Dups() + 1
+	mgr.pageZero.SetDups(dups)
+	return uid(dups)
+}
+
+// lastDup
+//
+// return the last unique id given by NewDup
+func (mgr *BufMgr) lastDup() uid {
+	mgr.lock.SpinReadLock()
+	defer mgr.lock.SpinReleaseRead()
+
+	return uid(mgr.pageZero.Dups())
+}
+
+// raiseDups
+//
+// make the unique ids given by NewDup
+// from now on larger than id
+func (mgr *BufMgr) raiseDups(id uid) {
+	mgr.lock.SpinWriteLock()
+	defer mgr.lock.SpinReleaseWrite()
+
+	if uint64(id) > mgr.pageZero.Dups() {
+		mgr.pageZero.SetDups(uint64(id))
+	}
+}
+
 // LockPage
 //
 // place write, read, or parent lock on requested page_no
 func (mgr *BufMgr) LockPage(mode BLTLockMode, latch *LatchSet) {
+	var waited bool
 	switch mode {
 	case LockRead:
-		latch.readWr.ReadLock()
+		waited = latch.readWr.ReadLock()
 	case LockWrite:
-		latch.readWr.WriteLock()
+		waited = latch.readWr.WriteLock()
+		mgr.preserve(latch)
 	case LockAccess:
-		latch.access.ReadLock()
+		waited = latch.access.ReadLock()
 	case LockDelete:
-		latch.access.WriteLock()
+		waited = latch.access.WriteLock()
 	case LockParent:
-		latch.parent.WriteLock()
-		//case LockAtomic: // Note: not supported in this golang implementation
+		waited = latch.parent.WriteLock()
+	}
+	if waited {
+		mgr.stats.latchWaits.Add(1)
 	}
 }
 
@@ -640,7 +978,6 @@ func (mgr *BufMgr) UnlockPage(mode BLTLockMode, latch *LatchSet) {
 		latch.access.WriteRelease()
 	case LockParent:
 		latch.parent.WriteRelease()
-		//case LockAtomic: // Note: not supported in this golang implementation
 	}
 
 }
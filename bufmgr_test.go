@@ -1,7 +1,9 @@
-package main
+package blinktree
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"os"
 	"reflect"
 	"testing"
@@ -29,17 +31,17 @@ func TestNewBufMgr(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			_ = os.Remove(tt.args.filename)
-			mgr := NewBufMgr(tt.args.filename, tt.args.bits, tt.args.nodeMax)
-			if mgr == nil {
-				t.Errorf("NewBufMgr() failed")
+			mgr, err := NewBufMgr(tt.args.filename, BtRW, tt.args.bits, tt.args.nodeMax)
+			if err != nil {
+				t.Fatalf("NewBufMgr() failed: %v", err)
 			}
 			page := Page{}
 			for i := 0; i < 3; i++ {
-				if err := mgr.readPage(&page, uid(i)); err != BLTErrOk {
+				if err := mgr.readPage(&page, uid(i)); err != nil {
 					t.Errorf("NewBufMgr() failed to read page. err: %v", err)
 				}
 			}
-			if err := mgr.readPage(&page, uid(3)); err != BLTErrRead {
+			if err := mgr.readPage(&page, uid(3)); !errors.Is(err, ErrRead) {
 				t.Errorf("NewBufMgr() failed to read page with unexpected err: %v", err)
 			}
 		})
@@ -69,9 +71,9 @@ func TestBufMgr_poolAudit(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			_ = os.Remove(tt.args.name)
-			mgr := NewBufMgr(tt.args.name, tt.args.bits, tt.args.nodeMax)
-			if mgr == nil {
-				t.Errorf("NewBufMgr() failed")
+			mgr, err := NewBufMgr(tt.args.name, BtRW, tt.args.bits, tt.args.nodeMax)
+			if err != nil {
+				t.Fatalf("NewBufMgr() failed: %v", err)
 			}
 			mgr.poolAudit()
 		})
@@ -204,16 +206,16 @@ func TestBufMgr_PinLatch(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			_ = os.Remove(tt.filename)
-			mgr := NewBufMgr(tt.filename, 15, 20)
-			if mgr == nil {
-				t.Errorf("NewBufMgr() failed")
+			mgr, err := NewBufMgr(tt.filename, BtRW, 15, 20)
+			if err != nil {
+				t.Fatalf("NewBufMgr() failed: %v", err)
 			}
 			if tt.args.pageNo > 2 {
 				// if pageNo is over 2, we need to write the page to disk
 				p := NewPage(mgr.pageDataSize)
 				mgr.writePage(p, tt.args.pageNo)
 			}
-			latch := mgr.PinLatch(tt.args.pageNo, tt.args.loadIt, &tt.args.reads, &tt.args.writes)
+			latch, _ := mgr.PinLatch(tt.args.pageNo, tt.args.loadIt, &tt.args.reads, &tt.args.writes)
 			if latch == nil && tt.wantLatched {
 				t.Errorf("PinLatch() failed to pin latch")
 			}
@@ -257,13 +259,13 @@ func TestBufMgr_PinLatch_Twice(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			_ = os.Remove(tt.filename)
-			mgr := NewBufMgr(tt.filename, 15, 20)
-			if mgr == nil {
-				t.Errorf("NewBufMgr() failed")
+			mgr, err := NewBufMgr(tt.filename, BtRW, 15, 20)
+			if err != nil {
+				t.Fatalf("NewBufMgr() failed: %v", err)
 			}
 
-			_ = mgr.PinLatch(tt.args.pageNo, false, &tt.args.reads, &tt.args.writes)
-			latch := mgr.PinLatch(tt.args.pageNo, false, &tt.args.reads, &tt.args.writes)
+			_, _ = mgr.PinLatch(tt.args.pageNo, false, &tt.args.reads, &tt.args.writes)
+			latch, _ := mgr.PinLatch(tt.args.pageNo, false, &tt.args.reads, &tt.args.writes)
 
 			if latch.pageNo != tt.args.pageNo {
 				t.Errorf("PinLatch() failed to set pageNo = %d, want %d", latch.pageNo, tt.args.pageNo)
@@ -309,14 +311,14 @@ func TestBufMgr_PinLatch_ClockWise(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			_ = os.Remove(tt.fields.filename)
-			mgr := NewBufMgr(tt.fields.filename, 15, tt.fields.nodeMax)
-			if mgr == nil {
-				t.Errorf("NewBufMgr() failed")
+			mgr, err := NewBufMgr(tt.fields.filename, BtRW, 15, tt.fields.nodeMax)
+			if err != nil {
+				t.Fatalf("NewBufMgr() failed: %v", err)
 			}
 
 			var unpinLatch *LatchSet
 			for i := 3; i < int(tt.fields.nodeMax)+2; i++ {
-				latch := mgr.PinLatch(uid(i), false, &tt.args.reads, &tt.args.writes)
+				latch, _ := mgr.PinLatch(uid(i), false, &tt.args.reads, &tt.args.writes)
 				if uid(i) == tt.fields.unpinPageNo {
 					unpinLatch = latch
 				}
@@ -325,7 +327,7 @@ func TestBufMgr_PinLatch_ClockWise(t *testing.T) {
 				mgr.UnpinLatch(unpinLatch)
 			}
 
-			latch := mgr.PinLatch(tt.args.pageNo, false, &tt.args.reads, &tt.args.writes)
+			latch, _ := mgr.PinLatch(tt.args.pageNo, false, &tt.args.reads, &tt.args.writes)
 
 			if latch.pageNo != tt.args.pageNo {
 				t.Errorf("PinLatch() failed to set pageNo = %d, want %d", latch.pageNo, tt.args.pageNo)
@@ -367,12 +369,12 @@ func TestBufMgr_UnpinLatch_ClockWise(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			_ = os.Remove(tt.fields.filename)
-			mgr := NewBufMgr(tt.fields.filename, 15, tt.fields.nodeMax)
-			if mgr == nil {
-				t.Errorf("NewBufMgr() failed")
+			mgr, err := NewBufMgr(tt.fields.filename, BtRW, 15, tt.fields.nodeMax)
+			if err != nil {
+				t.Fatalf("NewBufMgr() failed: %v", err)
 			}
 
-			latch := mgr.PinLatch(2, false, &tt.args.reads, &tt.args.writes)
+			latch, _ := mgr.PinLatch(2, false, &tt.args.reads, &tt.args.writes)
 			if latch.pin != 1 {
 				t.Errorf("PinLatch() failed to set pin = %d, want %d", latch.pin, 1)
 			}
@@ -416,15 +418,15 @@ func TestBufMgr_NewPage(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			_ = os.Remove(tt.filename)
-			mgr := NewBufMgr(tt.filename, 15, 20)
-			if mgr == nil {
-				t.Errorf("NewBufMgr() failed")
+			mgr, err := NewBufMgr(tt.filename, BtRW, 15, 20)
+			if err != nil {
+				t.Fatalf("NewBufMgr() failed: %v", err)
 			}
 			initialAllocRight := GetID(mgr.pageZero.AllocRight())
 			if initialAllocRight != MinLvl+1 {
 				t.Errorf("NewBufMgr() failed to initialize allock right")
 			}
-			if err := mgr.NewPage(&tt.args.pageSet, &tt.args.page, &tt.args.reads, &tt.args.writes); err != BLTErrOk {
+			if err := mgr.NewPage(&tt.args.pageSet, &tt.args.page, &tt.args.reads, &tt.args.writes); err != nil {
 				t.Errorf("NewPage() failed to create page with unexpected err: %v", err)
 			}
 
@@ -450,3 +452,91 @@ func TestBufMgr_NewPage(t *testing.T) {
 		})
 	}
 }
+
+func TestBufMgr_readPage_checksum(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(pageBytes []byte)
+	}{
+		{
+			name: "bit flip in page data",
+			corrupt: func(pageBytes []byte) {
+				pageBytes[PageHeaderSize+100] ^= 0x10
+			},
+		},
+		{
+			name: "bit flip in page header",
+			corrupt: func(pageBytes []byte) {
+				pageBytes[0] ^= 0x01
+			},
+		},
+		{
+			name: "torn write of the page tail",
+			corrupt: func(pageBytes []byte) {
+				copy(pageBytes[len(pageBytes)/2:], make([]byte, len(pageBytes)/2))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_ = os.Remove("data/read_page_checksum_test.db")
+			mgr, err := NewBufMgr("data/read_page_checksum_test.db", BtRW, 12, 20)
+			if err != nil {
+				t.Fatalf("NewBufMgr() failed: %v", err)
+			}
+			defer mgr.Close()
+
+			p := NewPage(mgr.pageDataSize)
+			p.Cnt = 1
+			for i := range p.Data {
+				p.Data[i] = byte(i)
+			}
+			if err := mgr.writePage(p, 3); err != nil {
+				t.Fatalf("writePage() err = %v", err)
+			}
+			var page Page
+			if err := mgr.readPage(&page, 3); err != nil {
+				t.Fatalf("readPage() err = %v", err)
+			}
+
+			pageBytes := make([]byte, mgr.pageSize)
+			if err := mgr.idx.ReadPage(3, pageBytes); err != nil {
+				t.Fatalf("ReadPage() err = %v", err)
+			}
+			tt.corrupt(pageBytes)
+			if err := mgr.idx.WritePage(3, pageBytes); err != nil {
+				t.Fatalf("WritePage() err = %v", err)
+			}
+
+			if err := mgr.readPage(&page, 3); !errors.Is(err, ErrCorrupt) || !errors.Is(err, ErrChecksum) {
+				t.Errorf("readPage() err = %v, want %v and %v", err, ErrCorrupt, ErrChecksum)
+			}
+		})
+	}
+}
+
+func TestNewBufMgr_version(t *testing.T) {
+	_ = os.Remove("data/buf_mgr_version_test.db")
+	mgr, err := NewBufMgr("data/buf_mgr_version_test.db", BtRW, 12, 20)
+	if err != nil {
+		t.Fatalf("NewBufMgr() failed: %v", err)
+	}
+	if err := mgr.Close(); err != nil {
+		t.Fatalf("Close() err = %v", err)
+	}
+
+	f, err := os.OpenFile("data/buf_mgr_version_test.db", os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile() err = %v", err)
+	}
+	version := make([]byte, 4)
+	binary.LittleEndian.PutUint32(version, FormatVersion+1)
+	if _, err := f.WriteAt(version, zeroVersion); err != nil {
+		t.Fatalf("WriteAt() err = %v", err)
+	}
+	_ = f.Close()
+
+	if _, err := NewBufMgr("data/buf_mgr_version_test.db", BtRW, 12, 20); !errors.Is(err, ErrVersion) {
+		t.Errorf("NewBufMgr() err = %v, want %v", err, ErrVersion)
+	}
+}
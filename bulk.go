@@ -0,0 +1,296 @@
+package blinktree
+
+import "fmt"
+
+/*
+ *  BulkLoad builds a tree from keys given in ascending order without
+ *  searching it: the leaves are filled from left to right, each new page
+ *  is linked from the right pointer of the previous one, and the fence
+ *  keys of a level become the keys of the level above. The level that
+ *  fits on a single page is written to the root page.
+ *
+ *  The first leaf reuses the leaf page of the new tree, the last page of
+ *  each level ends with the stopper key. Pages go through the buffer pool
+ *  and the write-ahead log like any update, so a failed or interrupted
+ *  load leaves the tree empty.
+ */
+
+// BulkIterator yields the keys and values loaded by BulkLoad,
+// in ascending key order
+type BulkIterator interface {
+	// Next advances to the next key, it returns false at the end
+	// of the keys or when Err is set
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Err() error
+}
+
+// BulkOptions configures BulkLoad
+type BulkOptions struct {
+	// FillFactor is the share of each page filled with keys, from 0.5
+	// to 1. Pages are packed fully by default; leave room on them when
+	// keys will be inserted later, to delay splits.
+	FillFactor float64
+}
+
+// bulkRef is a page completed by a levelWriter and its fence key,
+// the entry for it in the level above
+type bulkRef struct {
+	fence  []byte
+	pageNo uid
+}
+
+// levelWriter fills the pages of one level from left to right
+type levelWriter struct {
+	tree    *BLTree
+	lvl     uint8
+	limit   uint32    // bytes of a page filled before starting the next one
+	reserve uint32    // bytes kept free on each page for the stopper key
+	frame   *Page     // page being filled
+	latch   *LatchSet // pinned pool entry of the page being filled
+	refs    []bulkRef // pages written
+}
+
+// BulkLoad fills an empty tree with the keys of iter, which must be in
+// strictly ascending order, packing pages up to opts.FillFactor. It fails
+// with ErrNotEmpty on a tree that ever held keys, with ErrUnsorted when a
+// key is not above the previous one, and like Put on keys it does not
+// accept; the tree is left empty when the load fails.
+//
+// Other operations on the tree wait until the load is done.
+func (t *Tree) BulkLoad(iter BulkIterator, opts BulkOptions) error {
+	if err := t.writable(); err != nil {
+		return err
+	}
+	fill := opts.FillFactor
+	if fill == 0 {
+		fill = 1
+	} else if fill < 0.5 || fill > 1 {
+		return fmt.Errorf("blinktree: fill factor %v out of range [0.5, 1]", fill)
+	}
+
+	tree := t.handle()
+	defer t.release(tree)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// commit the updates before the load, so that
+	// a failed load rolls back to this point
+	if err := t.mgr.Commit(false); err != nil {
+		return err
+	}
+
+	if err := t.bulkLoad(tree, iter, fill); err != nil {
+		if rerr := t.mgr.Rollback(); rerr != nil {
+			return fmt.Errorf("%w (rollback: %w)", err, rerr)
+		}
+		return err
+	}
+
+	if t.opts.Durability == DurabilityNone {
+		return nil
+	}
+	return t.mgr.Commit(t.opts.Durability == DurabilityFsync)
+}
+
+func (t *Tree) bulkLoad(tree *BLTree, iter BulkIterator, fill float64) error {
+	mgr := t.mgr
+	limit := uint32(fill * float64(mgr.pageDataSize))
+
+	// a new tree holds the root, the leaf and the stopper key
+	if alloc := mgr.allocLimit(); alloc != MinLvl+1 {
+		return fmt.Errorf("%w: %d pages allocated", ErrNotEmpty, alloc-1)
+	}
+	leaf, err := mgr.PinLatch(LeafPage, true, &tree.reads, &tree.writes)
+	if err != nil {
+		return err
+	}
+	if cnt := mgr.MapPage(leaf).Cnt; cnt != 1 {
+		mgr.UnpinLatch(leaf)
+		return fmt.Errorf("%w: %d slots on the leaf page", ErrNotEmpty, cnt)
+	}
+
+	w := &levelWriter{
+		tree:    tree,
+		limit:   limit,
+		reserve: SlotSize + 2 + uint32(len(stopperKey)),
+		frame:   newBulkFrame(mgr, 0),
+		latch:   leaf,
+	}
+	if err := w.loadLeaves(iter, t.opts.MaxValueSize); err != nil {
+		if w.latch != nil {
+			mgr.UnpinLatch(w.latch)
+		}
+		return err
+	}
+
+	// build the levels above until one fits on the root page
+	refs := w.refs
+	for lvl := uint8(1); ; lvl++ {
+		if fitsPage(mgr, refs) {
+			root, err := mgr.PinLatch(RootPage, true, &tree.reads, &tree.writes)
+			if err != nil {
+				return err
+			}
+			w = &levelWriter{tree: tree, lvl: lvl, frame: newBulkFrame(mgr, lvl), latch: root}
+			for _, ref := range refs {
+				w.addRef(ref)
+			}
+			w.write()
+			return nil
+		}
+
+		w = &levelWriter{tree: tree, lvl: lvl, limit: limit, frame: newBulkFrame(mgr, lvl)}
+		if err := w.allocate(); err != nil {
+			return err
+		}
+		for _, ref := range refs {
+			if w.full(SlotSize + 2 + uint32(len(ref.fence)) + BtId) {
+				if err := w.next(); err != nil {
+					mgr.UnpinLatch(w.latch)
+					return err
+				}
+			}
+			w.addRef(ref)
+		}
+		w.write()
+		refs = w.refs
+	}
+}
+
+// loadLeaves fills the leaves with the keys of iter
+// and ends the last one with the stopper key
+func (w *levelWriter) loadLeaves(iter BulkIterator, maxValueSize int) error {
+	mgr := w.tree.mgr
+	var prev []byte
+	for iter.Next() {
+		key, value := iter.Key(), iter.Value()
+		if err := checkKey(key, MaxKey); err != nil {
+			return err
+		}
+		switch {
+		case len(value) > maxValueSize:
+			return fmt.Errorf("%w: %d bytes", ErrValueTooLarge, len(value))
+		case prev != nil && KeyCmp(prev, key) >= 0:
+			return fmt.Errorf("%w: key %q after %q", ErrUnsorted, key, prev)
+		}
+		prev = append(prev[:0], key...)
+
+		overflow := len(value) > mgr.maxInline()
+		if overflow {
+			ref, err := w.tree.writeOverflow(value)
+			if err != nil {
+				return err
+			}
+			value = ref
+		}
+
+		if w.full(SlotSize + 2 + uint32(len(key)+len(value))) {
+			if err := w.next(); err != nil {
+				return err
+			}
+		}
+		w.add(key, value, overflow)
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	w.add(stopperKey, nil, false)
+	w.write()
+	return nil
+}
+
+// newBulkFrame returns an empty page of level lvl
+func newBulkFrame(mgr *BufMgr, lvl uint8) *Page {
+	frame := NewPage(mgr.pageDataSize)
+	frame.Bits = mgr.pageBits
+	frame.Lvl = lvl
+	frame.Min = mgr.pageDataSize
+	return frame
+}
+
+// fitsPage reports whether the entries for refs fit on a single page
+func fitsPage(mgr *BufMgr, refs []bulkRef) bool {
+	var size uint32
+	for _, ref := range refs {
+		size += SlotSize + 2 + uint32(len(ref.fence)) + BtId
+	}
+	return size <= mgr.pageDataSize
+}
+
+// full reports whether an entry of n bytes, slot included, must go to
+// the next page. The first entry of a page always fits.
+func (w *levelWriter) full(n uint32) bool {
+	if w.frame.Cnt == 0 {
+		return false
+	}
+	used := w.frame.Cnt*SlotSize + w.tree.mgr.pageDataSize - w.frame.Min
+	return used+n > w.limit || used+n+w.reserve > w.tree.mgr.pageDataSize
+}
+
+// add appends a key and its value to the page being filled
+func (w *levelWriter) add(key, value []byte, overflow bool) {
+	frame := w.frame
+	frame.Cnt++
+	frame.Act++
+	frame.Min -= 2 + uint32(len(key)+len(value))
+
+	slot := frame.Cnt
+	frame.SetKeyOffset(slot, frame.Min)
+	frame.SetKey(key, slot)
+	frame.SetValue(value, slot)
+	frame.SetTyp(slot, Unique)
+	frame.SetOverflow(slot, overflow)
+}
+
+// addRef appends the entry of a page of the level below
+func (w *levelWriter) addRef(ref bulkRef) {
+	var value [BtId]byte
+	PutID(&value, ref.pageNo)
+	w.add(ref.fence, value[:], false)
+}
+
+// allocate pins a new page for the page being filled
+func (w *levelWriter) allocate() error {
+	var set PageSet
+	if err := w.tree.mgr.NewPage(&set, newBulkFrame(w.tree.mgr, w.lvl), &w.tree.reads, &w.tree.writes); err != nil {
+		return err
+	}
+	w.latch = set.latch
+	return nil
+}
+
+// next writes the page being filled, linked to a newly allocated page
+// that becomes the page being filled
+func (w *levelWriter) next() error {
+	latch := w.latch
+	if err := w.allocate(); err != nil {
+		w.latch = latch
+		return err
+	}
+	PutID(&w.frame.Right, w.latch.pageNo)
+
+	next := w.latch
+	w.latch = latch
+	w.write()
+	w.latch = next
+	w.frame = newBulkFrame(w.tree.mgr, w.lvl)
+	return nil
+}
+
+// write copies the page being filled to its pool entry, unpins it and
+// records its fence key for the level above
+func (w *levelWriter) write() {
+	mgr := w.tree.mgr
+	w.refs = append(w.refs, bulkRef{fence: w.frame.Key(w.frame.Cnt), pageNo: w.latch.pageNo})
+
+	mgr.LockPage(LockWrite, w.latch)
+	MemCpyPage(mgr.MapPage(w.latch), w.frame)
+	w.latch.dirty = true
+	mgr.UnlockPage(LockWrite, w.latch)
+	mgr.UnpinLatch(w.latch)
+	w.latch = nil
+}
@@ -0,0 +1,257 @@
+package blinktree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// sliceIterator is a BulkIterator over keys and values held in slices
+type sliceIterator struct {
+	keys, values [][]byte
+	i            int
+	err          error // returned by Err once the slices are exhausted
+}
+
+func (it *sliceIterator) Next() bool {
+	it.i++
+	return it.i <= len(it.keys)
+}
+
+func (it *sliceIterator) Key() []byte   { return it.keys[it.i-1] }
+func (it *sliceIterator) Value() []byte { return it.values[it.i-1] }
+
+func (it *sliceIterator) Err() error {
+	if it.i > len(it.keys) {
+		return it.err
+	}
+	return nil
+}
+
+func bulkKeys(num int) *sliceIterator {
+	it := &sliceIterator{}
+	for i := 0; i < num; i++ {
+		value := []byte(fmt.Sprintf("value%d", i))
+		if i%500 == 0 {
+			value = bytes.Repeat(value, 300) // stored on overflow pages
+		}
+		it.keys = append(it.keys, []byte(fmt.Sprintf("key%06d", i)))
+		it.values = append(it.values, value)
+	}
+	return it
+}
+
+func TestTree_BulkLoad(t *testing.T) {
+	tests := []struct {
+		name string
+		num  int
+		fill float64
+	}{
+		{name: "no keys", num: 0},
+		{name: "one key", num: 1},
+		{name: "packed", num: 20000},
+		{name: "fill factor", num: 20000, fill: 0.7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_ = os.Remove("data/bulk_load.db")
+			_ = os.Remove("data/bulk_load.db-wal")
+			tree, err := Open("data/bulk_load.db", Options{PageBits: BtMinBits, PoolSize: 64, Durability: DurabilityNone})
+			if err != nil {
+				t.Fatalf("Open() err = %v", err)
+			}
+
+			src := bulkKeys(tt.num)
+			if err := tree.BulkLoad(src, BulkOptions{FillFactor: tt.fill}); err != nil {
+				t.Fatalf("BulkLoad() err = %v", err)
+			}
+			checkTree(t, tree, tt.num)
+
+			if s, err := tree.Stats(); err != nil {
+				t.Fatalf("Stats() err = %v", err)
+			} else if want := tt.fill; tt.num > 1000 {
+				if want == 0 {
+					want = 1
+				}
+				if s.FillFactor < want-0.1 || s.FillFactor > want {
+					t.Errorf("Stats() FillFactor = %.3f, want about %.2f", s.FillFactor, want)
+				}
+			}
+
+			// the loaded tree is persisted and takes updates
+			if err := tree.Close(); err != nil {
+				t.Fatalf("Close() err = %v", err)
+			}
+			tree, err = Open("data/bulk_load.db", Options{PoolSize: 64, Durability: DurabilityNone})
+			if err != nil {
+				t.Fatalf("Open() err = %v", err)
+			}
+			defer tree.Close()
+			for i := 0; i < tt.num; i += 2 {
+				if err := tree.Delete(src.keys[i]); err != nil {
+					t.Fatalf("Delete() err = %v", err)
+				}
+			}
+			for i := 0; i < tt.num/2; i++ {
+				key := []byte(fmt.Sprintf("key%06d+", i))
+				if err := tree.Put(key, key); err != nil {
+					t.Fatalf("Put() err = %v", err)
+				}
+			}
+			checkTree(t, tree, tt.num/2+tt.num/2)
+		})
+	}
+}
+
+// checkTree checks the structure of tree and that it holds num keys
+func checkTree(t *testing.T, tree *Tree, num int) {
+	t.Helper()
+
+	report, err := tree.Check()
+	if err != nil {
+		t.Fatalf("Check() err = %v", err)
+	}
+	if err := report.Err(); err != nil {
+		t.Fatalf("Check() violations %v", report.Violations)
+	}
+	if report.Keys != uint64(num) {
+		t.Errorf("Check() Keys = %d, want %d", report.Keys, num)
+	}
+	if got := treeContents(t, tree); len(got) != num {
+		t.Errorf("Scan() visited %d keys, want %d", len(got), num)
+	}
+}
+
+func TestTree_BulkLoad_compactness(t *testing.T) {
+	num := 20000
+	src := bulkKeys(num)
+	pages := make(map[string]uint64)
+	for _, load := range []string{"put", "bulk"} {
+		tree, err := Open("", Options{InMemory: true, PageBits: BtMinBits, PoolSize: 64, Durability: DurabilityNone})
+		if err != nil {
+			t.Fatalf("Open() err = %v", err)
+		}
+		if load == "bulk" {
+			err = tree.BulkLoad(bulkKeys(num), BulkOptions{})
+		} else {
+			for i := 0; i < num && err == nil; i++ {
+				err = tree.Put(src.keys[i], src.values[i])
+			}
+		}
+		if err != nil {
+			t.Fatalf("%s: err = %v", load, err)
+		}
+
+		s, err := tree.Stats()
+		if err != nil {
+			t.Fatalf("Stats() err = %v", err)
+		}
+		pages[load] = s.Pages
+		tree.Close()
+	}
+
+	// sequential puts leave pages 90 percent full with librarian slots,
+	// bulk loaded pages are packed fully without them
+	if pages["bulk"]*100 > pages["put"]*85 {
+		t.Errorf("bulk load used %d pages, sequential puts %d", pages["bulk"], pages["put"])
+	}
+}
+
+func TestTree_BulkLoad_errors(t *testing.T) {
+	errIter := errors.New("iterator failed")
+	tests := []struct {
+		name string
+		src  func() *sliceIterator
+		want error
+	}{
+		{
+			name: "unsorted",
+			src: func() *sliceIterator {
+				src := bulkKeys(5000)
+				src.keys[4000], src.keys[4001] = src.keys[4001], src.keys[4000]
+				return src
+			},
+			want: ErrUnsorted,
+		},
+		{
+			name: "duplicate",
+			src: func() *sliceIterator {
+				src := bulkKeys(5000)
+				src.keys[10] = src.keys[9]
+				return src
+			},
+			want: ErrUnsorted,
+		},
+		{
+			name: "stopper key",
+			src: func() *sliceIterator {
+				src := bulkKeys(10)
+				src.keys = append(src.keys, []byte{0xff, 0xff, 1})
+				src.values = append(src.values, nil)
+				return src
+			},
+			want: ErrReservedKey,
+		},
+		{
+			name: "key too large",
+			src: func() *sliceIterator {
+				src := bulkKeys(10)
+				src.keys = append(src.keys, bytes.Repeat([]byte("z"), MaxKey+1))
+				src.values = append(src.values, nil)
+				return src
+			},
+			want: ErrKeyTooLarge,
+		},
+		{
+			name: "iterator error",
+			src: func() *sliceIterator {
+				src := bulkKeys(5000)
+				src.err = errIter
+				return src
+			},
+			want: errIter,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := Open("", Options{InMemory: true, PageBits: BtMinBits, PoolSize: 64})
+			if err != nil {
+				t.Fatalf("Open() err = %v", err)
+			}
+			defer tree.Close()
+
+			if err := tree.BulkLoad(tt.src(), BulkOptions{}); !errors.Is(err, tt.want) {
+				t.Fatalf("BulkLoad() err = %v, want %v", err, tt.want)
+			}
+
+			// the failed load left the tree empty and ready for another one
+			checkTree(t, tree, 0)
+			if err := tree.BulkLoad(bulkKeys(100), BulkOptions{}); err != nil {
+				t.Fatalf("BulkLoad() after failure err = %v", err)
+			}
+			checkTree(t, tree, 100)
+		})
+	}
+
+	t.Run("not empty", func(t *testing.T) {
+		tree, err := Open("", Options{InMemory: true, PoolSize: 16})
+		if err != nil {
+			t.Fatalf("Open() err = %v", err)
+		}
+		defer tree.Close()
+
+		if err := tree.Put([]byte("key"), []byte("value")); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+		if err := tree.BulkLoad(bulkKeys(10), BulkOptions{}); !errors.Is(err, ErrNotEmpty) {
+			t.Errorf("BulkLoad() err = %v, want %v", err, ErrNotEmpty)
+		}
+		if got, err := tree.Get([]byte("key")); err != nil || string(got) != "value" {
+			t.Errorf("Get() = %q, %v, want %q", got, err, "value")
+		}
+	})
+}
@@ -0,0 +1,349 @@
+package blinktree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+/*
+ *  Check walks the tree one level at a time from the root. The live
+ *  slots of the pages of a level give, in key order, the pages of the
+ *  level below and their fence keys, so the checker verifies that each
+ *  page ends with the fence key posted in its parent, that its keys are
+ *  above the fence of its left sibling and that its right link leads to
+ *  the next page of the level. The garbage of a page must count exactly
+ *  the bytes of its key area not held by live keys. The overflow chains
+ *  of the leaf values and the free chain of page zero are walked as
+ *  well, and every page below the allocation limit must be found
+ *  exactly once.
+ */
+
+// stopperKey is the last key of the rightmost page of each level
+var stopperKey = []byte{0xff, 0xff}
+
+// Violation is a problem found by Check
+type Violation struct {
+	PageNo  uint64 // page holding the problem
+	Slot    uint32 // slot holding the problem, 0 for the whole page
+	Problem string // description of the problem
+}
+
+func (v Violation) String() string {
+	if v.Slot > 0 {
+		return fmt.Sprintf("page %d slot %d: %s", v.PageNo, v.Slot, v.Problem)
+	}
+	return fmt.Sprintf("page %d: %s", v.PageNo, v.Problem)
+}
+
+// CheckReport is the result of Check
+type CheckReport struct {
+	Levels        int    // number of levels, leaves included
+	Pages         uint64 // number of tree pages reached from the root
+	OverflowPages uint64 // number of pages holding overflow values
+	FreePages     uint64 // number of pages on the free chain
+	Keys          uint64 // number of live keys on the leaves
+	Violations    []Violation
+}
+
+// Err returns an error wrapping ErrCorrupt when violations were found
+func (r *CheckReport) Err() error {
+	if len(r.Violations) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %d violations, first %s", ErrCorrupt, len(r.Violations), r.Violations[0])
+}
+
+// checkRef is a page expected on a level with the fence key
+// posted for it in its parent
+type checkRef struct {
+	pageNo uid
+	fence  []byte
+}
+
+type checker struct {
+	mgr    *BufMgr
+	report *CheckReport
+	alloc  uid            // first page number never allocated
+	seen   map[uid]string // pages found, with the role they were found in
+	reads  uint
+	writes uint
+}
+
+// Check verifies the structure of the tree and reports every violation
+// found. The returned error is only set when pages cannot be read, use
+// CheckReport.Err to test the result. Other operations wait for the
+// check to finish.
+func (t *Tree) Check() (*CheckReport, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c := &checker{
+		mgr:    t.mgr,
+		report: &CheckReport{},
+		alloc:  GetID(t.mgr.pageZero.AllocRight()),
+		seen:   make(map[uid]string),
+	}
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	return c.report, nil
+}
+
+func (c *checker) violation(pageNo uid, slot uint32, format string, a ...any) {
+	c.report.Violations = append(c.report.Violations, Violation{
+		PageNo:  uint64(pageNo),
+		Slot:    slot,
+		Problem: fmt.Sprintf(format, a...),
+	})
+}
+
+// read returns a copy of page pageNo, or nil after reporting why it
+// cannot be used. A page can only be found once.
+func (c *checker) read(pageNo uid, role string) (*Page, error) {
+	if pageNo <= AllocPage || pageNo >= c.alloc {
+		c.violation(pageNo, 0, "%s page number out of range, %d pages allocated", role, c.alloc)
+		return nil, nil
+	}
+	if prev, ok := c.seen[pageNo]; ok {
+		c.violation(pageNo, 0, "found as %s page, already found as %s page", role, prev)
+		return nil, nil
+	}
+	c.seen[pageNo] = role
+
+	latch, err := c.mgr.PinLatch(pageNo, true, &c.reads, &c.writes)
+	if errors.Is(err, ErrCorrupt) {
+		c.violation(pageNo, 0, "%v", err)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer c.mgr.UnpinLatch(latch)
+
+	page := NewPage(c.mgr.pageDataSize)
+	MemCpyPage(page, c.mgr.MapPage(latch))
+	return page, nil
+}
+
+func (c *checker) check() error {
+	if c.alloc <= MinLvl {
+		c.violation(AllocPage, 0, "allocation limit %d below the initial pages", c.alloc)
+		return nil
+	}
+
+	level := []checkRef{{pageNo: RootPage, fence: stopperKey}}
+	lvl := -1
+	for len(level) > 0 {
+		var next []checkRef
+		var lowFence []byte
+		for i, ref := range level {
+			page, err := c.read(ref.pageNo, "tree")
+			if err != nil {
+				return err
+			}
+			if page == nil {
+				lowFence = ref.fence
+				continue
+			}
+			c.report.Pages++
+
+			// the root gives the height of the tree
+			if lvl < 0 {
+				lvl = int(page.Lvl)
+				c.report.Levels = lvl + 1
+			}
+
+			var right uid
+			if i+1 < len(level) {
+				right = level[i+1].pageNo
+			}
+			children, err := c.checkPage(ref, page, uint8(lvl), lowFence, right)
+			if err != nil {
+				return err
+			}
+			next = append(next, children...)
+			lowFence = ref.fence
+		}
+
+		if lvl == 0 {
+			break
+		}
+		level = next
+		lvl--
+	}
+
+	if err := c.checkFree(); err != nil {
+		return err
+	}
+
+	for pageNo := uid(1); pageNo < c.alloc; pageNo++ {
+		if _, ok := c.seen[pageNo]; !ok {
+			c.violation(pageNo, 0, "neither reachable nor on the free chain")
+		}
+	}
+	return nil
+}
+
+// checkPage verifies a page of level lvl and returns its children
+func (c *checker) checkPage(ref checkRef, page *Page, lvl uint8, lowFence []byte, right uid) ([]checkRef, error) {
+	pageNo := ref.pageNo
+	size := c.mgr.pageDataSize
+
+	if page.Bits != c.mgr.pageBits {
+		c.violation(pageNo, 0, "page size bits %d, want %d", page.Bits, c.mgr.pageBits)
+	}
+	if page.Lvl != lvl {
+		c.violation(pageNo, 0, "level %d, want %d", page.Lvl, lvl)
+	}
+	if page.Free {
+		c.violation(pageNo, 0, "reachable page marked free")
+	}
+	if page.Kill {
+		c.violation(pageNo, 0, "page left half deleted")
+	}
+	if GetID(&page.Right) != right {
+		c.violation(pageNo, 0, "right link to page %d, want %d", GetID(&page.Right), right)
+	}
+	if page.Cnt == 0 {
+		c.violation(pageNo, 0, "no fence key")
+		return nil, nil
+	}
+	if page.Min > size || page.Cnt*SlotSize > page.Min {
+		c.violation(pageNo, 0, "slot array of %d slots overlaps key area at %d", page.Cnt, page.Min)
+		return nil, nil
+	}
+
+	var children []checkRef
+	var prev []byte
+	var act uint32
+	used := make(map[uint32]uint32) // live key and value bytes by key offset
+	for slot := uint32(1); slot <= page.Cnt; slot++ {
+		off := page.KeyOffset(slot)
+		if off < page.Min || off+2 > size || off+2+uint32(page.Data[off]) > size {
+			c.violation(pageNo, slot, "key offset %d outside of key area [%d, %d)", off, page.Min, size)
+			return nil, nil
+		}
+		valOff := page.ValueOffset(slot)
+		length := 2 + uint32(page.Data[off]) + uint32(page.Data[valOff])
+		if off+length > size {
+			c.violation(pageNo, slot, "value at %d runs past the page end", valOff)
+			return nil, nil
+		}
+
+		typ := page.Typ(slot)
+		key := page.Key(slot)
+		switch {
+		case typ > Duplicate:
+			c.violation(pageNo, slot, "unknown slot type %d", typ)
+		case typ == Librarian && !page.Dead(slot):
+			c.violation(pageNo, slot, "live librarian slot")
+		case typ == Librarian && slot == page.Cnt:
+			c.violation(pageNo, slot, "librarian slot as fence key")
+		}
+
+		if prev != nil {
+			if cmp := KeyCmp(prev, key); cmp > 0 || cmp == 0 && page.Typ(slot-1) != Librarian {
+				c.violation(pageNo, slot, "key %q not above previous key %q", key, prev)
+			}
+		} else if lowFence != nil && KeyCmp(key, lowFence) <= 0 {
+			c.violation(pageNo, slot, "key %q not above left sibling fence %q", key, lowFence)
+		}
+		prev = key
+
+		if page.Dead(slot) {
+			continue
+		}
+		act++
+		used[off] = length
+
+		value := *page.Value(slot)
+		switch {
+		case lvl > 0:
+			if len(value) != BtId {
+				c.violation(pageNo, slot, "child page number of %d bytes", len(value))
+				continue
+			}
+			children = append(children, checkRef{pageNo: GetIDFromValue(&value), fence: key})
+		case page.Overflow(slot):
+			if err := c.checkOverflow(pageNo, slot, value); err != nil {
+				return nil, err
+			}
+			c.report.Keys++
+		case slot == page.Cnt && right == 0 && bytes.Equal(key, stopperKey):
+			// the stopper key is not a user key
+		default:
+			c.report.Keys++
+		}
+	}
+
+	if act != page.Act {
+		c.violation(pageNo, 0, "active key count %d, %d live slots", page.Act, act)
+	}
+	var live uint32
+	for _, length := range used {
+		live += length
+	}
+	// every byte of the key area not held by a live key is garbage
+	if dead := size - page.Min - live; page.Garbage != dead {
+		c.violation(pageNo, 0, "garbage of %d bytes, %d bytes not in use", page.Garbage, dead)
+	}
+
+	if fence := page.Key(page.Cnt); !bytes.Equal(fence, ref.fence) {
+		c.violation(pageNo, page.Cnt, "fence key %q, parent separator %q", fence, ref.fence)
+	}
+	if lvl > 0 && len(children) == 0 {
+		c.violation(pageNo, 0, "branch page without children")
+	}
+	return children, nil
+}
+
+// checkOverflow verifies the overflow chain referenced by a leaf slot
+func (c *checker) checkOverflow(leaf uid, slot uint32, ref []byte) error {
+	size, pageNo, err := parseOverflowRef(ref)
+	if err != nil {
+		c.violation(leaf, slot, "overflow reference of %d bytes", len(ref))
+		return nil
+	}
+
+	var got int
+	for pageNo > 0 {
+		page, err := c.read(pageNo, "overflow")
+		if err != nil || page == nil {
+			return err
+		}
+		c.report.OverflowPages++
+
+		if page.Free {
+			c.violation(pageNo, 0, "overflow page marked free")
+		}
+		if page.Cnt == 0 || page.Cnt > c.mgr.pageDataSize {
+			c.violation(pageNo, 0, "overflow page holds %d bytes", page.Cnt)
+			return nil
+		}
+		got += int(page.Cnt)
+		pageNo = GetID(&page.Right)
+	}
+
+	if got != size {
+		c.violation(leaf, slot, "overflow chain holds %d of %d bytes", got, size)
+	}
+	return nil
+}
+
+// checkFree verifies the pages on the free chain of page zero
+func (c *checker) checkFree() error {
+	for pageNo := GetID(c.mgr.pageZero.Chain()); pageNo > 0; {
+		page, err := c.read(pageNo, "free")
+		if err != nil || page == nil {
+			return err
+		}
+		c.report.FreePages++
+
+		if !page.Free {
+			c.violation(pageNo, 0, "page on the free chain not marked free")
+		}
+		pageNo = GetID(&page.Right)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package blinktree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// newCheckTree returns an in-memory tree of several levels
+// with dead keys and overflow values
+func newCheckTree(t *testing.T) *Tree {
+	t.Helper()
+
+	tree, err := Open("", Options{InMemory: true, PageBits: BtMinBits, PoolSize: 64})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	for i := 0; i < 2000; i++ {
+		value := []byte(fmt.Sprintf("value%d", i))
+		if i%100 == 0 {
+			value = bytes.Repeat(value, 200) // stored on overflow pages
+		}
+		if err := tree.Put([]byte(fmt.Sprintf("key%05d", i)), value); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+	for i := 0; i < 2000; i += 3 {
+		if err := tree.Delete([]byte(fmt.Sprintf("key%05d", i))); err != nil {
+			t.Fatalf("Delete() err = %v", err)
+		}
+	}
+	return tree
+}
+
+// leftmostPage returns the number of the first page of level lvl
+func leftmostPage(t *testing.T, tree *Tree, lvl uint8) uid {
+	t.Helper()
+
+	var reads, writes uint
+	pageNo := uid(RootPage)
+	for {
+		latch, err := tree.mgr.PinLatch(pageNo, true, &reads, &writes)
+		if err != nil {
+			t.Fatalf("PinLatch() err = %v", err)
+		}
+		page := tree.mgr.MapPage(latch)
+		if page.Lvl == lvl {
+			tree.mgr.UnpinLatch(latch)
+			return pageNo
+		}
+		slot := uint32(1)
+		for page.Dead(slot) {
+			slot++
+		}
+		pageNo = GetIDFromValue(page.Value(slot))
+		tree.mgr.UnpinLatch(latch)
+	}
+}
+
+// corruptPage changes page pageNo in the buffer pool
+func corruptPage(t *testing.T, tree *Tree, pageNo uid, fn func(page *Page)) {
+	t.Helper()
+
+	var reads, writes uint
+	latch, err := tree.mgr.PinLatch(pageNo, true, &reads, &writes)
+	if err != nil {
+		t.Fatalf("PinLatch() err = %v", err)
+	}
+	fn(tree.mgr.MapPage(latch))
+	latch.dirty = true
+	tree.mgr.UnpinLatch(latch)
+}
+
+func TestTree_Check(t *testing.T) {
+	tree := newCheckTree(t)
+	defer tree.Close()
+
+	report, err := tree.Check()
+	if err != nil {
+		t.Fatalf("Check() err = %v", err)
+	}
+	if err := report.Err(); err != nil {
+		t.Fatalf("Check() violations %v", report.Violations)
+	}
+	if report.Keys != 1333 {
+		t.Errorf("Check() Keys = %d, want %d", report.Keys, 1333)
+	}
+	if report.Levels < 3 || report.OverflowPages == 0 {
+		t.Errorf("Check() Levels = %d, OverflowPages = %d, want a deeper tree with overflow pages", report.Levels, report.OverflowPages)
+	}
+	alloc := uint64(GetID(tree.mgr.pageZero.AllocRight()))
+	if got := 1 + report.Pages + report.OverflowPages + report.FreePages; got != alloc {
+		t.Errorf("Check() found %d pages, %d allocated", got, alloc)
+	}
+}
+
+func TestTree_Check_violations(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, tree *Tree)
+		want    string
+	}{
+		{
+			name: "keys out of order",
+			corrupt: func(t *testing.T, tree *Tree) {
+				corruptPage(t, tree, leftmostPage(t, tree, 0), func(page *Page) {
+					// swap the first two keys that are not librarian slots
+					var slots []uint32
+					for slot := uint32(1); len(slots) < 2; slot++ {
+						if page.Typ(slot) != Librarian {
+							slots = append(slots, slot)
+						}
+					}
+					off := page.KeyOffset(slots[0])
+					page.SetKeyOffset(slots[0], page.KeyOffset(slots[1]))
+					page.SetKeyOffset(slots[1], off)
+				})
+			},
+			want: "not above previous key",
+		},
+		{
+			name: "fence key",
+			corrupt: func(t *testing.T, tree *Tree) {
+				corruptPage(t, tree, leftmostPage(t, tree, 0), func(page *Page) {
+					off := page.KeyOffset(page.Cnt)
+					page.Data[off+uint32(page.Data[off])]++
+				})
+			},
+			want: "parent separator",
+		},
+		{
+			name: "active key count",
+			corrupt: func(t *testing.T, tree *Tree) {
+				corruptPage(t, tree, leftmostPage(t, tree, 0), func(page *Page) {
+					page.Act++
+				})
+			},
+			want: "active key count",
+		},
+		{
+			name: "garbage",
+			corrupt: func(t *testing.T, tree *Tree) {
+				corruptPage(t, tree, leftmostPage(t, tree, 0), func(page *Page) {
+					page.Garbage += tree.mgr.pageDataSize
+				})
+			},
+			want: "bytes not in use",
+		},
+		{
+			name: "garbage undercount",
+			corrupt: func(t *testing.T, tree *Tree) {
+				corruptPage(t, tree, leftmostPage(t, tree, 0), func(page *Page) {
+					page.Garbage--
+				})
+			},
+			want: "bytes not in use",
+		},
+		{
+			name: "right link",
+			corrupt: func(t *testing.T, tree *Tree) {
+				corruptPage(t, tree, leftmostPage(t, tree, 1), func(page *Page) {
+					PutID(&page.Right, 0)
+				})
+			},
+			want: "right link to page 0",
+		},
+		{
+			name: "level",
+			corrupt: func(t *testing.T, tree *Tree) {
+				corruptPage(t, tree, leftmostPage(t, tree, 0), func(page *Page) {
+					page.Lvl = 1
+				})
+			},
+			want: "level 1, want 0",
+		},
+		{
+			name: "reachable free page",
+			corrupt: func(t *testing.T, tree *Tree) {
+				corruptPage(t, tree, leftmostPage(t, tree, 0), func(page *Page) {
+					page.Free = true
+				})
+			},
+			want: "reachable page marked free",
+		},
+		{
+			name: "orphan page",
+			corrupt: func(t *testing.T, tree *Tree) {
+				alloc := GetID(tree.mgr.pageZero.AllocRight())
+				tree.mgr.pageZero.SetAllocRight(alloc + 1)
+			},
+			want: "neither reachable nor on the free chain",
+		},
+		{
+			name: "overflow chain",
+			corrupt: func(t *testing.T, tree *Tree) {
+				var reads, writes uint
+				for pageNo := leftmostPage(t, tree, 0); pageNo > 0; {
+					latch, err := tree.mgr.PinLatch(pageNo, true, &reads, &writes)
+					if err != nil {
+						t.Fatalf("PinLatch() err = %v", err)
+					}
+					page := tree.mgr.MapPage(latch)
+					for slot := uint32(1); slot <= page.Cnt; slot++ {
+						if page.Overflow(slot) && !page.Dead(slot) {
+							page.Data[page.ValueOffset(slot)+1]++
+							latch.dirty = true
+							tree.mgr.UnpinLatch(latch)
+							return
+						}
+					}
+					pageNo = GetID(&page.Right)
+					tree.mgr.UnpinLatch(latch)
+				}
+				t.Fatalf("no overflow value on the leaves")
+			},
+			want: "overflow chain holds",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := newCheckTree(t)
+			defer tree.Close()
+
+			tt.corrupt(t, tree)
+			report, err := tree.Check()
+			if err != nil {
+				t.Fatalf("Check() err = %v", err)
+			}
+			if err := report.Err(); !errors.Is(err, ErrCorrupt) {
+				t.Errorf("Err() = %v, want %v", err, ErrCorrupt)
+			}
+			for _, v := range report.Violations {
+				if strings.Contains(v.Problem, tt.want) {
+					return
+				}
+			}
+			t.Errorf("Check() violations %v, want one containing %q", report.Violations, tt.want)
+		})
+	}
+}
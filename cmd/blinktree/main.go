@@ -0,0 +1,545 @@
+// Command blinktree inspects and updates tree files.
+//
+// Usage:
+//
+//	blinktree get [-hex] <file> <key>
+//	blinktree put [-hex] <file> <key> <value>
+//	blinktree del [-hex] <file> <key>
+//	blinktree scan [-hex] [-prefix p] [-from k] [-to k] [-limit n] <file>
+//	blinktree stats <file>
+//	blinktree dump-page [-raw] <file> <page>
+//	blinktree export [-format dot|json] <file>
+//	blinktree check <file>
+//	blinktree compact <file>
+//
+// Keys and values are taken and printed as they are, or hex encoded
+// with -hex. Commands that only read open the file read-only, so they
+// can run beside another reader but not beside a writer.
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/hmarui66/blinktree"
+)
+
+// errViolations makes the command exit with status 1 without a message,
+// the violations have been printed already
+var errViolations = errors.New("violations found")
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "get", usage: "get [-hex] <file> <key>", run: runGet},
+		{name: "put", usage: "put [-hex] <file> <key> <value>", run: runPut},
+		{name: "del", usage: "del [-hex] <file> <key>", run: runDel},
+		{name: "scan", usage: "scan [-hex] [-prefix p] [-from k] [-to k] [-limit n] <file>", run: runScan},
+		{name: "stats", usage: "stats <file>", run: runStats},
+		{name: "dump-page", usage: "dump-page [-raw] <file> <page>", run: runDumpPage},
+		{name: "export", usage: "export [-format dot|json] <file>", run: runExport},
+		{name: "check", usage: "check <file>", run: runCheck},
+		{name: "compact", usage: "compact <file>", run: runCompact},
+	}
+}
+
+func lookup(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: blinktree <command> [arguments]")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "\tblinktree %s\n", cmd.usage)
+	}
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := lookup(flag.Arg(0))
+	if !ok {
+		fmt.Fprintf(os.Stderr, "blinktree: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(flag.Args()[1:]); err != nil {
+		if !errors.Is(err, errViolations) {
+			fmt.Fprintf(os.Stderr, "blinktree %s: %v\n", cmd.name, err)
+		}
+		os.Exit(1)
+	}
+}
+
+// flagSet returns the flag set of a command, with the -hex flag
+// when hexFlag is not nil
+func flagSet(name string, hexFlag *bool) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	if hexFlag != nil {
+		fs.BoolVar(hexFlag, "hex", false, "keys and values are hex encoded")
+	}
+	fs.Usage = func() {
+		cmd, _ := lookup(name)
+		fmt.Fprintf(os.Stderr, "usage: blinktree %s\n", cmd.usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses the arguments of a command, which takes n of them
+// after its flags
+func parseArgs(fs *flag.FlagSet, args []string, n int) error {
+	_ = fs.Parse(args)
+	if fs.NArg() != n {
+		return fmt.Errorf("expected %d arguments, got %d", n, fs.NArg())
+	}
+	return nil
+}
+
+// openTree opens the tree file at path. Values are not limited in
+// size, the file may have been written with a larger MaxValueSize.
+func openTree(path string, readOnly bool) (*blinktree.Tree, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return blinktree.Open(path, blinktree.Options{ReadOnly: readOnly, MaxValueSize: math.MaxInt32})
+}
+
+// closeTree closes tree, returning its error unless err is set
+func closeTree(tree *blinktree.Tree, err error) error {
+	if cerr := tree.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func decode(s string, isHex bool) ([]byte, error) {
+	if !isHex {
+		return []byte(s), nil
+	}
+	return hex.DecodeString(s)
+}
+
+func encode(b []byte, isHex bool) string {
+	if !isHex {
+		return string(b)
+	}
+	return hex.EncodeToString(b)
+}
+
+func runGet(args []string) error {
+	var isHex bool
+	fs := flagSet("get", &isHex)
+	if err := parseArgs(fs, args, 2); err != nil {
+		return err
+	}
+	key, err := decode(fs.Arg(1), isHex)
+	if err != nil {
+		return err
+	}
+
+	tree, err := openTree(fs.Arg(0), true)
+	if err != nil {
+		return err
+	}
+	value, err := tree.Get(key)
+	if err == nil {
+		fmt.Println(encode(value, isHex))
+	}
+	return closeTree(tree, err)
+}
+
+func runPut(args []string) error {
+	var isHex bool
+	fs := flagSet("put", &isHex)
+	if err := parseArgs(fs, args, 3); err != nil {
+		return err
+	}
+	key, err := decode(fs.Arg(1), isHex)
+	if err != nil {
+		return err
+	}
+	value, err := decode(fs.Arg(2), isHex)
+	if err != nil {
+		return err
+	}
+
+	tree, err := openTree(fs.Arg(0), false)
+	if err != nil {
+		return err
+	}
+	return closeTree(tree, tree.Put(key, value))
+}
+
+func runDel(args []string) error {
+	var isHex bool
+	fs := flagSet("del", &isHex)
+	if err := parseArgs(fs, args, 2); err != nil {
+		return err
+	}
+	key, err := decode(fs.Arg(1), isHex)
+	if err != nil {
+		return err
+	}
+
+	tree, err := openTree(fs.Arg(0), false)
+	if err != nil {
+		return err
+	}
+	return closeTree(tree, tree.Delete(key))
+}
+
+func runScan(args []string) error {
+	var isHex bool
+	fs := flagSet("scan", &isHex)
+	prefixArg := fs.String("prefix", "", "visit the keys starting with `p`")
+	fromArg := fs.String("from", "", "first key visited, inclusive")
+	toArg := fs.String("to", "", "key ending the scan, exclusive")
+	limit := fs.Int("limit", 0, "stop after `n` keys, 0 for no limit")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	var opts blinktree.IterOptions
+	var prefix []byte
+	for _, arg := range []struct {
+		value string
+		dest  *[]byte
+	}{{*prefixArg, &prefix}, {*fromArg, &opts.Start}, {*toArg, &opts.End}} {
+		if arg.value == "" {
+			continue
+		}
+		b, err := decode(arg.value, isHex)
+		if err != nil {
+			return err
+		}
+		*arg.dest = b
+	}
+
+	tree, err := openTree(fs.Arg(0), true)
+	if err != nil {
+		return err
+	}
+
+	var it *blinktree.Iterator
+	if prefix != nil {
+		if opts.Start != nil || opts.End != nil {
+			return closeTree(tree, errors.New("-prefix cannot be combined with -from or -to"))
+		}
+		it = tree.NewPrefixIterator(prefix)
+	} else {
+		it = tree.NewIterator(opts)
+	}
+
+	n := 0
+	for it.Seek(nil); it.Valid() && (*limit <= 0 || n < *limit); it.Next() {
+		fmt.Printf("%s\t%s\n", encode(it.Key(), isHex), encode(it.Value(), isHex))
+		n++
+	}
+	err = it.Err()
+	it.Close()
+	return closeTree(tree, err)
+}
+
+func runStats(args []string) error {
+	fs := flagSet("stats", nil)
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	tree, err := openTree(fs.Arg(0), true)
+	if err != nil {
+		return err
+	}
+	zero, err := tree.PageBytes(0)
+	if err != nil {
+		return closeTree(tree, err)
+	}
+	s, err := tree.Stats()
+	if err != nil {
+		return closeTree(tree, err)
+	}
+
+	fmt.Printf("page size\t%d\n", len(zero))
+	fmt.Printf("height\t%d\n", s.Height)
+	for lvl, pages := range s.LevelPages {
+		fmt.Printf("level %d pages\t%d\n", lvl, pages)
+	}
+	fmt.Printf("pages\t%d\n", s.Pages)
+	fmt.Printf("free pages\t%d\n", s.FreePages)
+	fmt.Printf("fill factor\t%.3f\n", s.FillFactor)
+	fmt.Printf("garbage bytes\t%d\n", s.GarbageBytes)
+	fmt.Printf("log frames\t%d\n", s.LogFrames)
+	return closeTree(tree, nil)
+}
+
+func runDumpPage(args []string) error {
+	fs := flagSet("dump-page", nil)
+	raw := fs.Bool("raw", false, "print the page bytes as a hex dump")
+	if err := parseArgs(fs, args, 2); err != nil {
+		return err
+	}
+	pageNo, err := strconv.ParseUint(fs.Arg(1), 10, 64)
+	if err != nil {
+		return err
+	}
+
+	tree, err := openTree(fs.Arg(0), true)
+	if err != nil {
+		return err
+	}
+	if *raw {
+		page, err := tree.PageBytes(pageNo)
+		if err == nil {
+			fmt.Print(hex.Dump(page))
+		}
+		return closeTree(tree, err)
+	}
+	page, err := tree.DumpPage(pageNo)
+	if err == nil {
+		fmt.Print(page)
+	}
+	return closeTree(tree, err)
+}
+
+func runExport(args []string) error {
+	fs := flagSet("export", nil)
+	format := fs.String("format", "dot", "output `format`, dot or json")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	var export func(*blinktree.Tree, io.Writer) error
+	switch *format {
+	case "dot":
+		export = (*blinktree.Tree).ExportDOT
+	case "json":
+		export = (*blinktree.Tree).ExportJSON
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	tree, err := openTree(fs.Arg(0), true)
+	if err != nil {
+		return err
+	}
+	return closeTree(tree, export(tree, os.Stdout))
+}
+
+func runCheck(args []string) error {
+	fs := flagSet("check", nil)
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	tree, err := openTree(fs.Arg(0), true)
+	if err != nil {
+		return err
+	}
+	report, err := tree.Check()
+	if err != nil {
+		return closeTree(tree, err)
+	}
+
+	for _, v := range report.Violations {
+		fmt.Println(v)
+	}
+	fmt.Printf("%d levels, %d pages, %d overflow pages, %d free pages, %d keys, %d violations\n",
+		report.Levels, report.Pages, report.OverflowPages, report.FreePages, report.Keys, len(report.Violations))
+	if len(report.Violations) > 0 {
+		return closeTree(tree, errViolations)
+	}
+	return closeTree(tree, nil)
+}
+
+// runCompact rewrites the tree into a new file, packed by BulkLoad
+// unless it holds duplicate keys, and replaces the tree file with it.
+// The tree file is locked while it is copied, other processes must not
+// reopen it until the command is done.
+func runCompact(args []string) error {
+	fs := flagSet("compact", nil)
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	path := fs.Arg(0)
+	tmp := path + ".compact"
+
+	src, err := openTree(path, false)
+	if err != nil {
+		return err
+	}
+	zero, err := src.PageBytes(0)
+	if err != nil {
+		return closeTree(src, err)
+	}
+	pageBits := uint8(bits.TrailingZeros(uint(len(zero))))
+
+	keys, err := rewrite(tmp, pageBits, src, true)
+	if errors.Is(err, errDuplicates) {
+		keys, err = rewrite(tmp, pageBits, src, false)
+	}
+	if err != nil {
+		return closeTree(src, err)
+	}
+	if err := src.Close(); err != nil {
+		return err
+	}
+
+	before, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	// the log of the old file was checkpointed by Close
+	if err := os.Remove(path + "-wal"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	if err := os.Remove(tmp + "-wal"); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d keys, %d bytes, was %d bytes\n", keys, after.Size(), before.Size())
+	return nil
+}
+
+// syncDir fsyncs directory dir, making the files renamed
+// or removed in it durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// rewrite copies every key of src into a new tree file at path, with
+// BulkLoad when bulk is set, and returns the number of keys copied.
+// The file is removed when the copy fails.
+func rewrite(path string, pageBits uint8, src *blinktree.Tree, bulk bool) (int, error) {
+	_ = os.Remove(path)
+	_ = os.Remove(path + "-wal")
+	dst, err := blinktree.Open(path, blinktree.Options{
+		PageBits:     pageBits,
+		MaxValueSize: math.MaxInt32,
+		Durability:   blinktree.DurabilityNone,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var keys int
+	if bulk {
+		it := &bulkSource{it: src.NewIterator(blinktree.IterOptions{})}
+		err = dst.BulkLoad(it, blinktree.BulkOptions{})
+		it.it.Close()
+		keys = it.keys
+	} else {
+		keys, err = copyTree(dst, src)
+	}
+
+	if err = closeTree(dst, err); err != nil {
+		_ = os.Remove(path)
+		_ = os.Remove(path + "-wal")
+	}
+	return keys, err
+}
+
+// errDuplicates stops a bulk load meeting duplicate keys,
+// which are copied one by one instead
+var errDuplicates = errors.New("duplicate keys")
+
+// bulkSource feeds the keys of a tree iterator to BulkLoad
+type bulkSource struct {
+	it      *blinktree.Iterator
+	started bool
+	keys    int
+	err     error
+}
+
+func (s *bulkSource) Next() bool {
+	if s.started {
+		s.it.Next()
+	} else {
+		s.it.Seek(nil)
+		s.started = true
+	}
+	if !s.it.Valid() {
+		return false
+	}
+	if s.it.DupID() != 0 {
+		s.err = errDuplicates
+		return false
+	}
+	s.keys++
+	return true
+}
+
+func (s *bulkSource) Key() []byte   { return s.it.Key() }
+func (s *bulkSource) Value() []byte { return s.it.Value() }
+
+func (s *bulkSource) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.it.Err()
+}
+
+// copyTree puts every key of src into dst, duplicate keys with their
+// unique id, and returns the number of keys copied
+func copyTree(dst, src *blinktree.Tree) (int, error) {
+	it := src.NewIterator(blinktree.IterOptions{})
+	defer it.Close()
+
+	n := 0
+	for it.Seek(nil); it.Valid(); it.Next() {
+		var err error
+		if it.DupID() != 0 {
+			err = dst.PutDupID(it.Key(), it.Value(), it.DupID())
+		} else {
+			err = dst.Put(it.Key(), it.Value())
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, it.Err()
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hmarui66/blinktree"
+)
+
+// run runs a command and returns what it printed
+func run(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	cmd, ok := lookup(args[0])
+	if !ok {
+		t.Fatalf("unknown command %q", args[0])
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe() err = %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	err = cmd.run(args[1:])
+	_ = w.Close()
+	return <-out, err
+}
+
+// entries returns the keys of the tree file at path with their
+// duplicate ids and values, in the order they are stored
+func entries(t *testing.T, path string) []string {
+	t.Helper()
+	tree, err := blinktree.Open(path, blinktree.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	var got []string
+	it := tree.NewIterator(blinktree.IterOptions{})
+	defer it.Close()
+	for it.Seek(nil); it.Valid(); it.Next() {
+		got = append(got, fmt.Sprintf("%s#%d=%s", it.Key(), it.DupID(), it.Value()))
+	}
+	if it.Err() != nil {
+		t.Fatalf("Err() = %v", it.Err())
+	}
+	return got
+}
+
+func TestCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	tree, err := blinktree.Open(path, blinktree.Options{})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close() err = %v", err)
+	}
+
+	for _, kv := range [][2]string{{"apple", "red"}, {"banana", "yellow"}, {"cherry", "dark"}} {
+		if _, err := run(t, "put", path, kv[0], kv[1]); err != nil {
+			t.Fatalf("put %s err = %v", kv[0], err)
+		}
+	}
+	if _, err := run(t, "put", "-hex", path, "6b6979", "00ff"); err != nil {
+		t.Fatalf("put -hex err = %v", err)
+	}
+	if _, err := run(t, "del", path, "banana"); err != nil {
+		t.Fatalf("del err = %v", err)
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{args: []string{"get", path, "apple"}, want: "red\n"},
+		{args: []string{"get", "-hex", path, "6b6979"}, want: "00ff\n"},
+		{args: []string{"scan", path}, want: "apple\tred\ncherry\tdark\nkiy\t\x00\xff\n"},
+		{args: []string{"scan", "-from", "b", "-to", "d", path}, want: "cherry\tdark\n"},
+		{args: []string{"scan", "-prefix", "ap", path}, want: "apple\tred\n"},
+		{args: []string{"scan", "-limit", "1", path}, want: "apple\tred\n"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args[:len(tt.args)-1], " "), func(t *testing.T) {
+			got, err := run(t, tt.args...)
+			if err != nil || got != tt.want {
+				t.Errorf("%v = %q, %v, want %q", tt.args, got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := run(t, "get", path, "banana"); err == nil {
+		t.Errorf("get of a deleted key err = nil")
+	}
+	if got, err := run(t, "check", path); err != nil || !strings.Contains(got, "3 keys, 0 violations") {
+		t.Errorf("check = %q, %v", got, err)
+	}
+	if got, err := run(t, "stats", path); err != nil || !strings.Contains(got, "height\t2\n") {
+		t.Errorf("stats = %q, %v", got, err)
+	}
+	if got, err := run(t, "dump-page", path, "1"); err != nil || got == "" {
+		t.Errorf("dump-page = %q, %v", got, err)
+	}
+	if got, err := run(t, "export", "-format", "json", path); err != nil || !strings.HasPrefix(got, "{") {
+		t.Errorf("export = %q, %v", got, err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	for _, dups := range []bool{false, true} {
+		t.Run(fmt.Sprintf("duplicates %v", dups), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tree.db")
+			tree, err := blinktree.Open(path, blinktree.Options{PageBits: 12, Durability: blinktree.DurabilityNone})
+			if err != nil {
+				t.Fatalf("Open() err = %v", err)
+			}
+			for i := 0; i < 3000; i++ {
+				if err := tree.Put([]byte(fmt.Sprintf("key%05d", i)), bytes.Repeat([]byte{'v'}, i%50)); err != nil {
+					t.Fatalf("Put() err = %v", err)
+				}
+			}
+			for i := 0; i < 3000; i += 2 {
+				if err := tree.Delete([]byte(fmt.Sprintf("key%05d", i))); err != nil {
+					t.Fatalf("Delete() err = %v", err)
+				}
+			}
+			if dups {
+				// leave gaps in the ids, compact keeps them
+				for i := 0; i < 10; i++ {
+					if _, err := tree.PutDup([]byte("dup"), []byte(fmt.Sprintf("value%d", i))); err != nil {
+						t.Fatalf("PutDup() err = %v", err)
+					}
+				}
+				for _, value := range []string{"value2", "value5"} {
+					if err := tree.DeleteDup([]byte("dup"), []byte(value)); err != nil {
+						t.Fatalf("DeleteDup() err = %v", err)
+					}
+				}
+			}
+			if err := tree.Close(); err != nil {
+				t.Fatalf("Close() err = %v", err)
+			}
+
+			want := entries(t, path)
+			before, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("Stat() err = %v", err)
+			}
+
+			if _, err := run(t, "compact", path); err != nil {
+				t.Fatalf("compact err = %v", err)
+			}
+
+			got := entries(t, path)
+			if strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("%d entries after compact, want %d:\n%s", len(got), len(want), strings.Join(got, "\n"))
+			}
+			if dups && !strings.Contains(strings.Join(got, " "), "dup#2=value1 dup#4=value3 dup#5=value4 dup#7=value6") {
+				t.Errorf("duplicate ids not kept: %v", got[:8])
+			}
+			if after, err := os.Stat(path); err != nil {
+				t.Errorf("Stat() err = %v", err)
+			} else if after.Size() >= before.Size() {
+				t.Errorf("size after compact %d, want below %d", after.Size(), before.Size())
+			}
+			if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+				t.Errorf("temporary file left: %v", err)
+			}
+			if out, err := run(t, "check", path); err != nil {
+				t.Errorf("check after compact = %q, %v", out, err)
+			}
+		})
+	}
+}
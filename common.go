@@ -1,4 +1,4 @@
-package main
+package blinktree
 
 import (
 	"fmt"
@@ -15,7 +15,7 @@ const (
 	BtRO = 0x6f72 // ro
 	BtRW = 0x7772 // rw
 
-	BtMaxBits = 24             // maximum page size in bits
+	BtMaxBits = 15             // maximum page size in bits, slot offsets hold 15 bits
 	BtMinBits = 9              // minimum page size in bits
 	BtMinPage = 1 << BtMinBits // minimum page size
 	BtMaxPage = 1 << BtMaxBits // maximum page size
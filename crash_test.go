@@ -0,0 +1,373 @@
+package blinktree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+)
+
+var errFault = errors.New("injected fault")
+
+// faultStore is a memory PageStore that fails or tears writes, fails
+// reads on demand and loses the writes not synced when it crashes
+type faultStore struct {
+	mu       sync.Mutex
+	data     []byte       // contents seen by reads
+	durable  []byte       // contents surviving a crash
+	unsynced []faultWrite // writes since the last sync, in order
+
+	writesLeft int  // writes to accept before failing all of them, -1 for no limit
+	tear       bool // the first failing write is partly stored
+	readsLeft  int  // reads to accept before failing one, -1 for no limit
+}
+
+type faultWrite struct {
+	off  int64
+	data []byte
+}
+
+func newFaultStore(data []byte) *faultStore {
+	return &faultStore{
+		data:       data,
+		durable:    append([]byte(nil), data...),
+		writesLeft: -1,
+		readsLeft:  -1,
+	}
+}
+
+func (s *faultStore) ReadPage(pageNo uint64, buf []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.readsLeft == 0 {
+		s.readsLeft = -1
+		return errFault
+	}
+	if s.readsLeft > 0 {
+		s.readsLeft--
+	}
+
+	off := int64(pageNo) * int64(len(buf))
+	if off >= int64(len(s.data)) {
+		return io.EOF
+	}
+	if n := copy(buf, s.data[off:]); n < len(buf) {
+		return io.EOF
+	}
+	return nil
+}
+
+func (s *faultStore) WritePage(pageNo uint64, buf []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.writesLeft == 0 {
+		if s.tear {
+			s.tear = false
+			s.write(int64(pageNo)*int64(len(buf)), buf[:len(buf)/2])
+		}
+		return errFault
+	}
+	if s.writesLeft > 0 {
+		s.writesLeft--
+	}
+
+	s.write(int64(pageNo)*int64(len(buf)), buf)
+	return nil
+}
+
+func (s *faultStore) write(off int64, buf []byte) {
+	if end := off + int64(len(buf)); end > int64(len(s.data)) {
+		s.data = append(s.data, make([]byte, end-int64(len(s.data)))...)
+	}
+	copy(s.data[off:], buf)
+	s.unsynced = append(s.unsynced, faultWrite{off: off, data: append([]byte(nil), buf...)})
+}
+
+func (s *faultStore) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.writesLeft == 0 {
+		return errFault
+	}
+	s.durable = append(s.durable[:0], s.data...)
+	s.unsynced = nil
+	return nil
+}
+
+func (s *faultStore) Size() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return int64(len(s.data)), nil
+}
+
+func (s *faultStore) Close() error {
+	return nil
+}
+
+// crash returns the store as found after a power loss: each write not
+// synced may have been stored, lost or torn
+func (s *faultStore) crash(rng *rand.Rand) *faultStore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := append([]byte(nil), s.durable...)
+	for _, w := range s.unsynced {
+		buf := w.data
+		switch rng.Intn(3) {
+		case 0:
+			continue
+		case 1:
+			buf = buf[:rng.Intn(len(buf))]
+		}
+		if end := w.off + int64(len(buf)); end > int64(len(data)) {
+			data = append(data, make([]byte, end-int64(len(data)))...)
+		}
+		copy(data[w.off:], buf)
+	}
+
+	return newFaultStore(data)
+}
+
+// crashOp is an update of the crash workload,
+// a nil value deletes the key
+type crashOp struct {
+	key   string
+	value []byte
+}
+
+func applyCrashOps(model map[string][]byte, ops []crashOp) map[string][]byte {
+	next := make(map[string][]byte, len(model))
+	for key, value := range model {
+		next[key] = value
+	}
+	for _, op := range ops {
+		if op.value == nil {
+			delete(next, op.key)
+		} else {
+			next[op.key] = op.value
+		}
+	}
+	return next
+}
+
+// treeContents returns every key and value of the tree,
+// failing when the keys are not in ascending order
+func treeContents(t *testing.T, tree *Tree) map[string][]byte {
+	t.Helper()
+
+	contents := make(map[string][]byte)
+	var last []byte
+	err := tree.Scan(nil, nil, func(key, value []byte) bool {
+		if last != nil && bytes.Compare(last, key) >= 0 {
+			t.Fatalf("Scan() key %q after %q", key, last)
+		}
+		last = key
+		contents[string(key)] = value
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Scan() err = %v", err)
+	}
+
+	for key, value := range contents {
+		if got, err := tree.Get([]byte(key)); err != nil || !bytes.Equal(got, value) {
+			t.Fatalf("Get(%q) = %d bytes, %v, want the %d bytes scanned", key, len(got), err, len(value))
+		}
+	}
+	return contents
+}
+
+func equalContents(a, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if v, ok := b[key]; !ok || !bytes.Equal(v, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func diffContents(got, want map[string][]byte) string {
+	var keys []string
+	for key, value := range want {
+		if v, ok := got[key]; !ok || !bytes.Equal(v, value) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range got {
+		if _, ok := want[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > 5 {
+		keys = keys[:5]
+	}
+	return fmt.Sprintf("%d keys, want %d, first differences %q", len(got), len(want), keys)
+}
+
+// TestTree_crashConsistency runs random workloads on fault stores,
+// crashes them at random points and checks that the reopened tree is
+// ordered and holds every acknowledged update. The update in progress
+// at the crash is either applied completely or not at all.
+func TestTree_crashConsistency(t *testing.T) {
+	seeds := 20
+	if testing.Short() {
+		seeds = 5
+	}
+
+	for seed := 0; seed < seeds; seed++ {
+		seed := seed
+		t.Run(fmt.Sprintf("seed %d", seed), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(int64(seed)))
+			store, log := newFaultStore(nil), newFaultStore(nil)
+
+			model := make(map[string][]byte)
+			var pending []crashOp
+			for life := 0; life < 4; life++ {
+				tree, err := OpenStore(store, log, Options{PageBits: BtMinBits, PoolSize: 16})
+				if err != nil {
+					t.Fatalf("life %d: OpenStore() err = %v", life, err)
+				}
+
+				got := treeContents(t, tree)
+				if after := applyCrashOps(model, pending); equalContents(got, after) {
+					model = after
+				} else if !equalContents(got, model) {
+					t.Fatalf("life %d: tree holds %s", life, diffContents(got, model))
+				}
+				pending = nil
+
+				// arm the faults ending this life, the last one closes cleanly
+				if life < 3 {
+					switch rng.Intn(4) {
+					case 0:
+						log.writesLeft, log.tear = rng.Intn(300), rng.Intn(2) == 0
+					case 1:
+						store.writesLeft, store.tear = rng.Intn(20), rng.Intn(2) == 0
+					case 2:
+						store.readsLeft = rng.Intn(200)
+					}
+				}
+
+				for i := 0; i < 300 && pending == nil; i++ {
+					var ops []crashOp
+					for n := 1 + rng.Intn(3)*rng.Intn(8); n > 0; n-- {
+						op := crashOp{key: fmt.Sprintf("key%04d", rng.Intn(1000))}
+						if rng.Intn(4) > 0 {
+							size := rng.Intn(40)
+							if rng.Intn(50) == 0 {
+								size = 2000 // stored on overflow pages
+							}
+							op.value = bytes.Repeat([]byte{byte(i)}, size)
+						}
+						ops = append(ops, op)
+					}
+
+					if len(ops) > 1 {
+						var b Batch
+						for _, op := range ops {
+							if op.value == nil {
+								b.Delete([]byte(op.key))
+							} else {
+								b.Put([]byte(op.key), op.value)
+							}
+						}
+						err = tree.Write(&b)
+					} else if ops[0].value == nil {
+						err = tree.Delete([]byte(ops[0].key))
+					} else {
+						err = tree.Put([]byte(ops[0].key), ops[0].value)
+					}
+
+					if err != nil {
+						if !errors.Is(err, errFault) {
+							t.Fatalf("life %d: update err = %v, want %v", life, err, errFault)
+						}
+						pending = ops
+					} else {
+						model = applyCrashOps(model, ops)
+					}
+				}
+
+				if life == 3 {
+					if err := tree.Close(); err != nil {
+						t.Fatalf("Close() err = %v", err)
+					}
+					store, log = store.crash(rng), log.crash(rng)
+					tree, err = OpenStore(store, log, Options{PageBits: BtMinBits, PoolSize: 16})
+					if err != nil {
+						t.Fatalf("OpenStore() after Close() err = %v", err)
+					}
+					if got := treeContents(t, tree); !equalContents(got, model) {
+						t.Fatalf("after Close() tree holds %s", diffContents(got, model))
+					}
+					break
+				}
+
+				// crash without closing, the tree is abandoned
+				store, log = store.crash(rng), log.crash(rng)
+			}
+		})
+	}
+}
+
+func TestTree_readFault(t *testing.T) {
+	store, log := newFaultStore(nil), newFaultStore(nil)
+	tree, err := OpenStore(store, log, Options{PageBits: BtMinBits, PoolSize: 16})
+	if err != nil {
+		t.Fatalf("OpenStore() err = %v", err)
+	}
+
+	num := 1000
+	for i := 0; i < num; i++ {
+		key := []byte(fmt.Sprintf("key%04d", i))
+		if err := tree.Put(key, key); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close() err = %v", err)
+	}
+
+	tree, err = OpenStore(store, log, Options{PageBits: BtMinBits, PoolSize: 16})
+	if err != nil {
+		t.Fatalf("OpenStore() err = %v", err)
+	}
+	defer tree.Close()
+
+	// a failed read is reported and retried by the next access
+	failed := 0
+	for i := 0; i < num; i++ {
+		key := []byte(fmt.Sprintf("key%04d", i))
+		if i%100 == 0 {
+			store.mu.Lock()
+			store.readsLeft = 0
+			store.mu.Unlock()
+		}
+		got, err := tree.Get(key)
+		if err != nil {
+			if !errors.Is(err, ErrRead) || !errors.Is(err, errFault) {
+				t.Fatalf("Get(%q) err = %v, want %v wrapping %v", key, err, ErrRead, errFault)
+			}
+			failed++
+			got, err = tree.Get(key)
+		}
+		if err != nil || !bytes.Equal(got, key) {
+			t.Fatalf("Get(%q) = %q, %v, want %q", key, got, err, key)
+		}
+	}
+	if failed == 0 {
+		t.Errorf("no read failed")
+	}
+}
@@ -0,0 +1,337 @@
+package blinktree
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// PageDump is a page decoded by DumpPage
+type PageDump struct {
+	PageNo  uint64     `json:"page"`
+	Cnt     uint32     `json:"cnt"`     // count of keys in page
+	Act     uint32     `json:"act"`     // count of active keys
+	Min     uint32     `json:"min"`     // next key offset
+	Garbage uint32     `json:"garbage"` // page garbage in bytes
+	Bits    uint8      `json:"bits"`    // page size in bits
+	Free    bool       `json:"free"`    // page is on free chain
+	Lvl     uint8      `json:"lvl"`     // level of page
+	Kill    bool       `json:"kill"`    // page is being deleted
+	Right   uint64     `json:"right"`   // page number to right
+	Slots   []SlotDump `json:"slots"`
+}
+
+// SlotDump is a key slot of a PageDump
+type SlotDump struct {
+	Slot     uint32   `json:"slot"`
+	Typ      SlotType `json:"type"`
+	Dead     bool     `json:"dead"`
+	Overflow bool     `json:"overflow"` // Value is an overflow reference
+	Off      uint32   `json:"off"`      // key offset
+	Key      []byte   `json:"key"`
+	Value    []byte   `json:"value"`
+	Child    uint64   `json:"child,omitempty"` // page the value points to on branch pages
+}
+
+// String formats the page header and one line per slot
+func (d *PageDump) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "page %d: lvl %d cnt %d act %d min %d garbage %d bits %d right %d",
+		d.PageNo, d.Lvl, d.Cnt, d.Act, d.Min, d.Garbage, d.Bits, d.Right)
+	if d.Free {
+		b.WriteString(" free")
+	}
+	if d.Kill {
+		b.WriteString(" kill")
+	}
+	b.WriteByte('\n')
+
+	for _, s := range d.Slots {
+		fmt.Fprintf(&b, "%5d %-9s off %5d", s.Slot, s.Typ, s.Off)
+		if s.Dead {
+			b.WriteString(" dead")
+		}
+		fmt.Fprintf(&b, " key %s", FormatKey(s.Key))
+		switch {
+		case s.Child > 0:
+			fmt.Fprintf(&b, " child %d", s.Child)
+		case s.Overflow:
+			fmt.Fprintf(&b, " overflow %x", s.Value)
+		default:
+			fmt.Fprintf(&b, " value %s", FormatKey(s.Value))
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// FormatKey returns key quoted when it is printable text,
+// hex encoded with a 0x prefix otherwise
+func FormatKey(key []byte) string {
+	for _, c := range key {
+		if c < 0x20 || c > 0x7e {
+			return "0x" + hex.EncodeToString(key)
+		}
+	}
+	return strconv.Quote(string(key))
+}
+
+// allocLimit returns the first page number never allocated
+func (mgr *BufMgr) allocLimit() uid {
+	mgr.lock.SpinReadLock()
+	defer mgr.lock.SpinReleaseRead()
+
+	return GetID(mgr.pageZero.AllocRight())
+}
+
+// readPageCopy returns a copy of page pageNo read under a read lock
+func (mgr *BufMgr) readPageCopy(pageNo uid) (*Page, error) {
+	if alloc := mgr.allocLimit(); pageNo == 0 || pageNo >= alloc {
+		return nil, fmt.Errorf("%w: page %d, %d pages allocated", ErrNotFound, pageNo, alloc)
+	}
+
+	var reads, writes uint
+	latch, err := mgr.PinLatch(pageNo, true, &reads, &writes)
+	if err != nil {
+		return nil, err
+	}
+	defer mgr.UnpinLatch(latch)
+
+	mgr.LockPage(LockRead, latch)
+	defer mgr.UnlockPage(LockRead, latch)
+
+	page := NewPage(mgr.pageDataSize)
+	MemCpyPage(page, mgr.MapPage(latch))
+	return page, nil
+}
+
+// PageBytes returns page pageNo as it is written to the tree file,
+// including updates not committed yet
+func (t *Tree) PageBytes(pageNo uint64) ([]byte, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	mgr := t.mgr
+	if pageNo == 0 {
+		mgr.lock.SpinReadLock()
+		zero := append([]byte(nil), mgr.pageZero.alloc...)
+		mgr.lock.SpinReleaseRead()
+
+		setPageSum(zero)
+		return zero, nil
+	}
+
+	page, err := mgr.readPageCopy(uid(pageNo))
+	if err != nil {
+		return nil, err
+	}
+	return mgr.encodePage(page, uid(pageNo))
+}
+
+// DumpPage decodes the header and key slots of page pageNo, including
+// updates not committed yet. Page zero holds no slots.
+func (t *Tree) DumpPage(pageNo uint64) (*PageDump, error) {
+	pageBytes, err := t.PageBytes(pageNo)
+	if err != nil {
+		return nil, err
+	}
+
+	var page Page
+	if err := decodePage(&page, pageBytes, uid(pageNo)); err != nil {
+		return nil, err
+	}
+	return dumpPage(uid(pageNo), &page), nil
+}
+
+// dumpPage decodes page, reporting the slots that do not fit
+// in the page as dead slots without key or value
+func dumpPage(pageNo uid, page *Page) *PageDump {
+	d := &PageDump{
+		PageNo:  uint64(pageNo),
+		Cnt:     page.Cnt,
+		Act:     page.Act,
+		Min:     page.Min,
+		Garbage: page.Garbage,
+		Bits:    page.Bits,
+		Free:    page.Free,
+		Lvl:     page.Lvl,
+		Kill:    page.Kill,
+		Right:   uint64(GetID(&page.Right)),
+	}
+	if pageNo == 0 || page.Free {
+		return d
+	}
+
+	size := uint32(len(page.Data))
+	for slot := uint32(1); slot <= page.Cnt && slot*SlotSize <= size; slot++ {
+		s := SlotDump{
+			Slot:     slot,
+			Typ:      page.Typ(slot),
+			Dead:     page.Dead(slot),
+			Overflow: page.Overflow(slot),
+			Off:      page.KeyOffset(slot),
+		}
+		if off := s.Off; off+1 < size && off+1+uint32(page.Data[off]) < size {
+			s.Key = page.Key(slot)
+			if valOff := page.ValueOffset(slot); valOff+1+uint32(page.Data[valOff]) <= size {
+				s.Value = *page.Value(slot)
+			}
+		}
+		if page.Lvl > 0 && len(s.Value) == BtId {
+			s.Child = uint64(GetIDFromValue(&s.Value))
+		}
+		d.Slots = append(d.Slots, s)
+	}
+	return d
+}
+
+// ExportPage is a page of the tree rendered by ExportJSON
+type ExportPage struct {
+	PageNo   uint64        `json:"page"`
+	Right    uint64        `json:"right"` // page number to right, 0 for the last page of the level
+	Cnt      uint32        `json:"cnt"`
+	Act      uint32        `json:"act"`
+	Fence    string        `json:"fence"` // fence key as formatted by FormatKey
+	Children []ExportChild `json:"children,omitempty"`
+}
+
+// ExportChild is a live separator of a branch page and its child page
+type ExportChild struct {
+	Key    string `json:"key"`
+	PageNo uint64 `json:"page"`
+}
+
+// ExportLevel is a level of the tree rendered by ExportJSON,
+// its pages follow the right links from the leftmost page
+type ExportLevel struct {
+	Lvl   uint8        `json:"lvl"`
+	Pages []ExportPage `json:"pages"`
+}
+
+// walkLevels calls fn with a copy of every page of the tree, level by
+// level from the root down, following the right links of each level.
+// Updates must be excluded by the caller.
+func (t *Tree) walkLevels(fn func(pageNo uid, page *Page) error) error {
+	seen := make(map[uid]bool)
+	for pageNo := RootPage; pageNo > 0; {
+		var lvl uint8
+		var down uid
+		for first := true; pageNo > 0; first = false {
+			if seen[pageNo] {
+				return fmt.Errorf("%w: page %d found twice", ErrCorrupt, pageNo)
+			}
+			seen[pageNo] = true
+
+			page, err := t.mgr.readPageCopy(pageNo)
+			if err != nil {
+				return err
+			}
+			if first {
+				lvl = page.Lvl
+			} else if page.Lvl != lvl {
+				return fmt.Errorf("%w: page %d of level %d linked from level %d", ErrCorrupt, pageNo, page.Lvl, lvl)
+			}
+			if err := fn(pageNo, page); err != nil {
+				return err
+			}
+
+			// the leftmost child of the first page starts the level below
+			for slot := uint32(1); lvl > 0 && down == 0 && slot <= page.Cnt; slot++ {
+				if !page.Dead(slot) {
+					down = GetIDFromValue(page.Value(slot))
+				}
+			}
+			pageNo = GetID(&page.Right)
+		}
+
+		if lvl == 0 {
+			break
+		}
+		pageNo = down
+	}
+	return nil
+}
+
+// exportTree renders every level of the tree from the root down
+func (t *Tree) exportTree() ([]ExportLevel, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var levels []ExportLevel
+	err := t.walkLevels(func(pageNo uid, page *Page) error {
+		d := dumpPage(pageNo, page)
+		if len(levels) == 0 || levels[len(levels)-1].Lvl != d.Lvl {
+			levels = append(levels, ExportLevel{Lvl: d.Lvl})
+		}
+		level := &levels[len(levels)-1]
+
+		p := ExportPage{PageNo: d.PageNo, Right: d.Right, Cnt: d.Cnt, Act: d.Act}
+		if len(d.Slots) > 0 {
+			p.Fence = FormatKey(d.Slots[len(d.Slots)-1].Key)
+		}
+		for _, s := range d.Slots {
+			if !s.Dead && s.Child > 0 {
+				p.Children = append(p.Children, ExportChild{Key: FormatKey(s.Key), PageNo: s.Child})
+			}
+		}
+		level.Pages = append(level.Pages, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
+
+// ExportJSON writes the pages of every level of the tree, root first,
+// with their right links, fence keys and children as JSON
+func (t *Tree) ExportJSON(w io.Writer) error {
+	levels, err := t.exportTree()
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Levels []ExportLevel `json:"levels"`
+	}{levels})
+}
+
+// ExportDOT writes the tree as a Graphviz digraph: one row of pages per
+// level labelled with their fence keys, solid edges to children and
+// dashed edges along the right links
+func (t *Tree) ExportDOT(w io.Writer) error {
+	levels, err := t.exportTree()
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph blinktree {\n\tnode [shape=box];\n")
+	for _, level := range levels {
+		b.WriteString("\t{ rank=same;")
+		for _, p := range level.Pages {
+			fmt.Fprintf(&b, " p%d;", p.PageNo)
+		}
+		b.WriteString(" }\n")
+
+		for _, p := range level.Pages {
+			label := fmt.Sprintf("page %d lvl %d\ncnt %d act %d\nfence %s", p.PageNo, level.Lvl, p.Cnt, p.Act, p.Fence)
+			fmt.Fprintf(&b, "\tp%d [label=%s];\n", p.PageNo, strconv.Quote(label))
+			for _, c := range p.Children {
+				fmt.Fprintf(&b, "\tp%d -> p%d [label=%s];\n", p.PageNo, c.PageNo, strconv.Quote(c.Key))
+			}
+			if p.Right > 0 {
+				fmt.Fprintf(&b, "\tp%d -> p%d [style=dashed, constraint=false];\n", p.PageNo, p.Right)
+			}
+		}
+	}
+	b.WriteString("}\n")
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,188 @@
+package blinktree
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTree_PageBytes(t *testing.T) {
+	tree, err := Open("", Options{InMemory: true, PageBits: BtMinBits, PoolSize: 16})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	for i := 0; i < 500; i++ {
+		key := []byte(fmt.Sprintf("key%04d", i))
+		if err := tree.Put(key, key); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+
+	alloc := uint64(GetID(tree.mgr.pageZero.AllocRight()))
+	for pageNo := uint64(0); pageNo < alloc; pageNo++ {
+		pageBytes, err := tree.PageBytes(pageNo)
+		if err != nil {
+			t.Fatalf("PageBytes(%d) err = %v", pageNo, err)
+		}
+		if len(pageBytes) != int(tree.mgr.pageSize) {
+			t.Fatalf("PageBytes(%d) = %d bytes, want %d", pageNo, len(pageBytes), tree.mgr.pageSize)
+		}
+		var page Page
+		if err := decodePage(&page, pageBytes, uid(pageNo)); err != nil {
+			t.Errorf("PageBytes(%d) decodePage() err = %v", pageNo, err)
+		}
+	}
+
+	if _, err := tree.PageBytes(alloc); !errors.Is(err, ErrNotFound) {
+		t.Errorf("PageBytes(%d) err = %v, want %v", alloc, err, ErrNotFound)
+	}
+}
+
+func TestTree_DumpPage(t *testing.T) {
+	tree, err := Open("", Options{InMemory: true, PageBits: BtMinBits, PoolSize: 16})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	// a new tree holds the stopper key on the root and the leaf
+	root, err := tree.DumpPage(uint64(RootPage))
+	if err != nil {
+		t.Fatalf("DumpPage(%d) err = %v", RootPage, err)
+	}
+	if root.Lvl != 1 || len(root.Slots) != 1 || root.Slots[0].Child != LeafPage || !bytes.Equal(root.Slots[0].Key, stopperKey) {
+		t.Errorf("DumpPage(%d) = %+v, want a level 1 page pointing to page %d", RootPage, root, LeafPage)
+	}
+
+	for _, key := range []string{"b", "a", "c"} {
+		if err := tree.Put([]byte(key), []byte("value "+key)); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+	if err := tree.Delete([]byte("b")); err != nil {
+		t.Fatalf("Delete() err = %v", err)
+	}
+
+	leaf, err := tree.DumpPage(LeafPage)
+	if err != nil {
+		t.Fatalf("DumpPage(%d) err = %v", LeafPage, err)
+	}
+	want := []struct {
+		key   string
+		value string
+		dead  bool
+	}{
+		{"a", "value a", false},
+		{"b", "value b", true},
+		{"c", "value c", false},
+		{"\xff\xff", "", false},
+	}
+	var slots []SlotDump
+	for _, s := range leaf.Slots {
+		if s.Typ != Librarian {
+			slots = append(slots, s)
+		}
+	}
+	if leaf.Cnt != uint32(len(leaf.Slots)) || leaf.Act != 3 || len(slots) != len(want) {
+		t.Fatalf("DumpPage(%d) = %v, want %d slots besides librarian slots", LeafPage, leaf, len(want))
+	}
+	for i, w := range want {
+		s := slots[i]
+		if string(s.Key) != w.key || string(s.Value) != w.value || s.Dead != w.dead || s.Typ != Unique {
+			t.Errorf("DumpPage(%d) slot %d = %+v, want %q, %q, dead %v", LeafPage, s.Slot, s, w.key, w.value, w.dead)
+		}
+	}
+	if s := leaf.String(); !strings.Contains(s, `dead key "b" value "value b"`) {
+		t.Errorf("String() = %q, want the dead key b", s)
+	}
+
+	if _, err := tree.DumpPage(1000); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DumpPage(1000) err = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestTree_ExportJSON(t *testing.T) {
+	tree := newCheckTree(t)
+	defer tree.Close()
+
+	var buf bytes.Buffer
+	if err := tree.ExportJSON(&buf); err != nil {
+		t.Fatalf("ExportJSON() err = %v", err)
+	}
+	var export struct {
+		Levels []ExportLevel `json:"levels"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &export); err != nil {
+		t.Fatalf("json.Unmarshal() err = %v", err)
+	}
+
+	report, err := tree.Check()
+	if err != nil {
+		t.Fatalf("Check() err = %v", err)
+	}
+	if len(export.Levels) != report.Levels {
+		t.Fatalf("ExportJSON() has %d levels, want %d", len(export.Levels), report.Levels)
+	}
+
+	pages := 0
+	var parents []uint64 // children of the level above
+	for i, level := range export.Levels {
+		if want := uint8(report.Levels - 1 - i); level.Lvl != want {
+			t.Errorf("level %d lvl = %d, want %d", i, level.Lvl, want)
+		}
+
+		var pageNos, children []uint64
+		for j, p := range level.Pages {
+			pageNos = append(pageNos, p.PageNo)
+			if j+1 < len(level.Pages) && p.Right != level.Pages[j+1].PageNo {
+				t.Errorf("page %d right = %d, want %d", p.PageNo, p.Right, level.Pages[j+1].PageNo)
+			}
+			for _, c := range p.Children {
+				children = append(children, c.PageNo)
+			}
+		}
+		if i > 0 && fmt.Sprint(pageNos) != fmt.Sprint(parents) {
+			t.Errorf("level %d pages = %v, want the children %v", level.Lvl, pageNos, parents)
+		}
+		parents = children
+
+		last := level.Pages[len(level.Pages)-1]
+		if last.Right != 0 || last.Fence != FormatKey(stopperKey) {
+			t.Errorf("level %d last page = %+v, want the stopper key without right link", level.Lvl, last)
+		}
+		pages += len(level.Pages)
+	}
+	if uint64(pages) != report.Pages {
+		t.Errorf("ExportJSON() has %d pages, want %d", pages, report.Pages)
+	}
+}
+
+func TestTree_ExportDOT(t *testing.T) {
+	tree := newCheckTree(t)
+	defer tree.Close()
+
+	var buf bytes.Buffer
+	if err := tree.ExportDOT(&buf); err != nil {
+		t.Fatalf("ExportDOT() err = %v", err)
+	}
+	report, err := tree.Check()
+	if err != nil {
+		t.Fatalf("Check() err = %v", err)
+	}
+
+	dot := buf.String()
+	if !strings.HasPrefix(dot, "digraph blinktree {") || !strings.HasSuffix(dot, "}\n") {
+		t.Errorf("ExportDOT() = %q, want a digraph", dot)
+	}
+	if n := strings.Count(dot, "[label=\"page "); uint64(n) != report.Pages {
+		t.Errorf("ExportDOT() has %d pages, want %d", n, report.Pages)
+	}
+	if n := strings.Count(dot, "style=dashed"); uint64(n) != report.Pages-uint64(report.Levels) {
+		t.Errorf("ExportDOT() has %d right links, want %d", n, report.Pages-uint64(report.Levels))
+	}
+}
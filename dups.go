@@ -0,0 +1,253 @@
+package blinktree
+
+import (
+	"bytes"
+	"fmt"
+)
+
+/*
+ *  Duplicate keys are stored in Duplicate slots whose key is extended
+ *  by the BtId bytes of a unique id taken from the global counter in
+ *  page zero. The id is stored big endian, so the duplicates of a key
+ *  are kept in insertion order. Unique keys that extend the key by up to
+ *  BtId bytes sort between them.
+ *
+ *  A key should be stored either with Put or with PutDup. Get returns
+ *  the first value stored for a key, unique or duplicate.
+ */
+
+// dupKey returns key extended by the duplicate key unique id
+func dupKey(key []byte, id uid) []byte {
+	var seq [BtId]byte
+	PutID(&seq, id)
+	return append(append(make([]byte, 0, len(key)+BtId), key...), seq[:]...)
+}
+
+// findDups
+//
+// call fn with the unique id and value of each live duplicate of key
+// in insertion order until fn returns false. A unique key is passed
+// with id 0.
+func (tree *BLTree) findDups(key []byte, valMax int, fn func(id uid, value []byte) bool) error {
+	var set PageSet
+	slot, err := tree.loadPage(&set, key, 0, LockRead)
+	if err != nil {
+		return err
+	}
+
+	// unique keys extending key sort between its duplicates,
+	// the duplicates end before key followed by the largest id
+	last := append(append(make([]byte, 0, len(key)+BtId), key...), bytes.Repeat([]byte{0xff}, BtId)...)
+
+	tree.err = nil
+	for ; slot > 0; slot = tree.findNext(&set, slot) {
+		// not there if we reach the stopper key
+		if slot == set.page.Cnt && GetID(&set.page.Right) == 0 {
+			break
+		}
+		if set.page.Dead(slot) || set.page.Typ(slot) == Librarian {
+			continue
+		}
+
+		ptr := set.page.Key(slot)
+		if KeyCmp(ptr, last) > 0 {
+			break
+		}
+		var id uid
+		if set.page.Typ(slot) == Duplicate {
+			id = GetID((*[BtId]byte)(ptr[len(ptr)-BtId:]))
+			ptr = ptr[:len(ptr)-BtId]
+		}
+		if KeyCmp(ptr, key) != 0 {
+			continue
+		}
+
+		val := *set.page.Value(slot)
+		if set.page.Overflow(slot) {
+			if val, err = tree.readOverflow(val); err != nil {
+				break
+			}
+		}
+		if valMax < len(val) {
+			val = val[:valMax]
+		}
+		if !fn(id, append([]byte(nil), val...)) {
+			break
+		}
+	}
+
+	// findNext leaves the page read locked on error
+	tree.mgr.UnlockPage(LockRead, set.latch)
+	tree.mgr.UnpinLatch(set.latch)
+
+	if err != nil {
+		return err
+	}
+	return tree.err
+}
+
+// findRaw
+//
+// return the value of the live slot holding exactly
+// the stored key raw, including a duplicate suffix
+func (tree *BLTree) findRaw(raw []byte, valMax int) (found bool, value []byte, err error) {
+	var set PageSet
+	slot, err := tree.loadPage(&set, raw, 0, LockRead)
+	if err != nil {
+		return false, nil, err
+	}
+
+	// skip librarian slot place holder
+	if set.page.Typ(slot) == Librarian && slot < set.page.Cnt {
+		slot++
+	}
+
+	if !set.page.Dead(slot) && KeyCmp(set.page.Key(slot), raw) == 0 {
+		val := *set.page.Value(slot)
+		if set.page.Overflow(slot) {
+			val, err = tree.readOverflow(val)
+		}
+		if err == nil {
+			if valMax < len(val) {
+				val = val[:valMax]
+			}
+			found, value = true, append([]byte(nil), val...)
+		}
+	}
+
+	tree.mgr.UnlockPage(LockRead, set.latch)
+	tree.mgr.UnpinLatch(set.latch)
+
+	return found, value, err
+}
+
+// PutDup stores value as a new duplicate of key and returns its
+// unique id, which DeleteDupID accepts
+func (t *Tree) PutDup(key, value []byte) (uint64, error) {
+	if err := t.writable(); err != nil {
+		return 0, err
+	}
+	if err := checkKey(key, MaxKey-BtId); err != nil {
+		return 0, err
+	}
+	if len(value) > t.opts.MaxValueSize {
+		return 0, fmt.Errorf("%w: %d bytes", ErrValueTooLarge, len(value))
+	}
+
+	tree := t.handle()
+	defer t.release(tree)
+
+	t.mu.RLock()
+	err := tree.insertKey(key, 0, value, false)
+	t.mu.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(tree.dup), t.commit()
+}
+
+// PutDupID stores value as the duplicate of key with the unique id id,
+// as returned by PutDup or Iterator.DupID, replacing the value of that
+// duplicate if it is already stored. PutDup returns larger ids afterwards.
+func (t *Tree) PutDupID(key, value []byte, id uint64) error {
+	if err := t.writable(); err != nil {
+		return err
+	}
+	if err := checkKey(key, MaxKey-BtId); err != nil {
+		return err
+	}
+	if len(value) > t.opts.MaxValueSize {
+		return fmt.Errorf("%w: %d bytes", ErrValueTooLarge, len(value))
+	}
+	if id == 0 || id >= 1<<(8*BtId) {
+		return fmt.Errorf("blinktree: duplicate id %d out of range", id)
+	}
+
+	tree := t.handle()
+	defer t.release(tree)
+
+	t.mu.RLock()
+	t.mgr.raiseDups(uid(id))
+	err := tree.insertEntry(dupKey(key, uid(id)), 0, value, Duplicate)
+	t.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	return t.commit()
+}
+
+// GetAll returns the values stored for key in insertion order
+func (t *Tree) GetAll(key []byte) ([][]byte, error) {
+	var values [][]byte
+	err := t.scanDups(key, func(id uid, value []byte) bool {
+		values = append(values, value)
+		return true
+	})
+
+	return values, err
+}
+
+// CountDups returns the number of values stored for key
+func (t *Tree) CountDups(key []byte) (int, error) {
+	count := 0
+	err := t.scanDups(key, func(id uid, value []byte) bool {
+		count++
+		return true
+	})
+
+	return count, err
+}
+
+// DeleteDup removes the first duplicate of key holding value.
+// Deleting a missing duplicate is not an error.
+func (t *Tree) DeleteDup(key, value []byte) error {
+	if err := t.writable(); err != nil {
+		return err
+	}
+
+	var match uid
+	err := t.scanDups(key, func(id uid, v []byte) bool {
+		if id > 0 && bytes.Equal(v, value) {
+			match = id
+			return false
+		}
+		return true
+	})
+	if err != nil || match == 0 {
+		return err
+	}
+
+	return t.DeleteDupID(key, uint64(match))
+}
+
+// DeleteDupID removes the duplicate of key with the unique id returned by
+// PutDup. Deleting a missing duplicate is not an error.
+func (t *Tree) DeleteDupID(key []byte, id uint64) error {
+	if err := t.writable(); err != nil {
+		return err
+	}
+
+	tree := t.handle()
+	defer t.release(tree)
+
+	t.mu.RLock()
+	err := tree.deleteKey(dupKey(key, uid(id)), 0)
+	t.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	return t.commit()
+}
+
+func (t *Tree) scanDups(key []byte, fn func(id uid, value []byte) bool) error {
+	tree := t.handle()
+	defer t.release(tree)
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return tree.findDups(key, t.opts.MaxValueSize, fn)
+}
@@ -0,0 +1,241 @@
+package blinktree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestTree_duplicates(t *testing.T) {
+	_ = os.Remove("data/tree_duplicates.db")
+	tree, err := Open("data/tree_duplicates.db", Options{PoolSize: 32, Durability: DurabilityNone})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	for _, key := range []string{"color:blu", "color:reds"} {
+		if err := tree.Put([]byte(key), []byte("unique")); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+
+	key := []byte("color:red")
+	large := bytes.Repeat([]byte{0x33}, 2*int(tree.mgr.pageDataSize))
+	values := [][]byte{[]byte("apple"), []byte("cherry"), large, []byte("apple")}
+	var ids []uint64
+	for _, value := range values {
+		id, err := tree.PutDup(key, value)
+		if err != nil {
+			t.Fatalf("PutDup() err = %v", err)
+		}
+		if len(ids) > 0 && id <= ids[len(ids)-1] {
+			t.Errorf("PutDup() id = %d, want > %d", id, ids[len(ids)-1])
+		}
+		ids = append(ids, id)
+	}
+
+	assertValues := func(t *testing.T, want [][]byte) {
+		t.Helper()
+		got, err := tree.GetAll(key)
+		if err != nil {
+			t.Fatalf("GetAll() err = %v", err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("GetAll() = %d values, want %d", len(got), len(want))
+		}
+		for i := range want {
+			if !bytes.Equal(got[i], want[i]) {
+				t.Errorf("GetAll()[%d] = %d bytes, want %d", i, len(got[i]), len(want[i]))
+			}
+		}
+		if count, err := tree.CountDups(key); err != nil || count != len(want) {
+			t.Errorf("CountDups() = %d, %v, want %d", count, err, len(want))
+		}
+	}
+	assertValues(t, values)
+
+	if got, err := tree.Get(key); err != nil || !bytes.Equal(got, values[0]) {
+		t.Errorf("Get() = %q, %v, want %q", got, err, values[0])
+	}
+
+	t.Run("iterator strips the suffix", func(t *testing.T) {
+		it := tree.NewPrefixIterator(key)
+		defer it.Close()
+
+		i := 0
+		for it.Seek(nil); it.Valid(); it.Next() {
+			if i == len(values) {
+				if !bytes.Equal(it.Key(), []byte("color:reds")) || it.DupID() != 0 {
+					t.Errorf("Key() = %q, DupID() = %d, want %q, 0", it.Key(), it.DupID(), "color:reds")
+				}
+			} else {
+				if !bytes.Equal(it.Key(), key) || it.DupID() != ids[i] || !bytes.Equal(it.Value(), values[i]) {
+					t.Errorf("Key() = %q, DupID() = %d, want %q, %d", it.Key(), it.DupID(), key, ids[i])
+				}
+			}
+			i++
+		}
+		if i != len(values)+1 {
+			t.Errorf("iterator visited %d keys, want %d", i, len(values)+1)
+		}
+	})
+
+	t.Run("delete by value", func(t *testing.T) {
+		if err := tree.DeleteDup(key, []byte("apple")); err != nil {
+			t.Fatalf("DeleteDup() err = %v", err)
+		}
+		assertValues(t, values[1:])
+
+		if err := tree.DeleteDup(key, []byte("banana")); err != nil {
+			t.Fatalf("DeleteDup() of missing value err = %v", err)
+		}
+		assertValues(t, values[1:])
+	})
+
+	t.Run("delete by id", func(t *testing.T) {
+		if err := tree.DeleteDupID(key, ids[2]); err != nil {
+			t.Fatalf("DeleteDupID() err = %v", err)
+		}
+		assertValues(t, [][]byte{values[1], values[3]})
+	})
+
+	for _, key := range []string{"color:blu", "color:reds"} {
+		if count, err := tree.CountDups([]byte(key)); err != nil || count != 1 {
+			t.Errorf("CountDups(%q) = %d, %v, want 1", key, count, err)
+		}
+	}
+}
+
+func TestTree_duplicatesManyPages(t *testing.T) {
+	_ = os.Remove("data/tree_duplicates_many.db")
+	tree, err := Open("data/tree_duplicates_many.db", Options{PoolSize: 32, Durability: DurabilityNone})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+
+	// interleave the duplicates of a few keys so they span many leaf pages
+	num := 3000
+	for i := 0; i < num; i++ {
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, uint64(i))
+		if _, err := tree.PutDup([]byte(fmt.Sprintf("key%d", i%3)), value); err != nil {
+			t.Fatalf("PutDup() err = %v", err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close() err = %v", err)
+	}
+
+	tree, err = Open("data/tree_duplicates_many.db", Options{PoolSize: 32})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	values, err := tree.GetAll([]byte("key1"))
+	if err != nil {
+		t.Fatalf("GetAll() err = %v", err)
+	}
+	if len(values) != num/3 {
+		t.Fatalf("GetAll() = %d values, want %d", len(values), num/3)
+	}
+	for i, value := range values {
+		if got, want := binary.BigEndian.Uint64(value), uint64(3*i+1); got != want {
+			t.Fatalf("GetAll()[%d] = %d, want %d", i, got, want)
+		}
+	}
+
+	// ids keep increasing after reopen
+	id, err := tree.PutDup([]byte("key1"), []byte("last"))
+	if err != nil {
+		t.Fatalf("PutDup() err = %v", err)
+	}
+	if id <= uint64(num) {
+		t.Errorf("PutDup() id = %d, want > %d", id, num)
+	}
+	if count, err := tree.CountDups([]byte("key1")); err != nil || count != num/3+1 {
+		t.Errorf("CountDups() = %d, %v, want %d", count, err, num/3+1)
+	}
+}
+
+func TestTree_duplicatesAroundExtendedKey(t *testing.T) {
+	tree, err := Open("", Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	// "ab\x00" sorts between the duplicates of "ab"
+	key := []byte("ab")
+	if _, err := tree.PutDup(key, []byte("v1")); err != nil {
+		t.Fatalf("PutDup() err = %v", err)
+	}
+	if err := tree.Put([]byte("ab\x00"), []byte("unique")); err != nil {
+		t.Fatalf("Put() err = %v", err)
+	}
+	if _, err := tree.PutDup(key, []byte("v2")); err != nil {
+		t.Fatalf("PutDup() err = %v", err)
+	}
+
+	values, err := tree.GetAll(key)
+	if err != nil {
+		t.Fatalf("GetAll() err = %v", err)
+	}
+	if len(values) != 2 || string(values[0]) != "v1" || string(values[1]) != "v2" {
+		t.Errorf("GetAll() = %q, want %q", values, []string{"v1", "v2"})
+	}
+	if count, err := tree.CountDups(key); err != nil || count != 2 {
+		t.Errorf("CountDups() = %d, %v, want 2", count, err)
+	}
+
+	if err := tree.DeleteDup(key, []byte("v2")); err != nil {
+		t.Fatalf("DeleteDup() err = %v", err)
+	}
+	if values, err := tree.GetAll(key); err != nil || len(values) != 1 || string(values[0]) != "v1" {
+		t.Errorf("GetAll() = %q, %v, want %q", values, err, []string{"v1"})
+	}
+	if got, err := tree.Get([]byte("ab\x00")); err != nil || string(got) != "unique" {
+		t.Errorf("Get() = %q, %v, want %q", got, err, "unique")
+	}
+}
+
+func TestTree_PutDupID(t *testing.T) {
+	tree, err := Open("", Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	key := []byte("key")
+	for _, dup := range []struct {
+		id    uint64
+		value string
+	}{{100, "first"}, {50, "second"}, {100, "third"}} {
+		if err := tree.PutDupID(key, []byte(dup.value), dup.id); err != nil {
+			t.Fatalf("PutDupID(%d) err = %v", dup.id, err)
+		}
+	}
+
+	// duplicates are ordered by id, storing an id again replaces its value
+	it := tree.NewPrefixIterator(key)
+	var ids []uint64
+	var values []string
+	for it.Seek(nil); it.Valid(); it.Next() {
+		ids = append(ids, it.DupID())
+		values = append(values, string(it.Value()))
+	}
+	it.Close()
+	if fmt.Sprint(ids) != "[50 100]" || fmt.Sprint(values) != "[second third]" {
+		t.Errorf("iterator ids = %v, values = %q, want [50 100], [second third]", ids, values)
+	}
+
+	if id, err := tree.PutDup(key, []byte("last")); err != nil || id <= 100 {
+		t.Errorf("PutDup() = %d, %v, want an id above 100", id, err)
+	}
+	if err := tree.PutDupID(key, nil, 0); err == nil {
+		t.Errorf("PutDupID() with id 0 err = nil")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package blinktree
+
+import "os"
+
+// lockFile is a no-op on platforms without flock,
+// concurrent opens of the btree file are not detected there
+func lockFile(file *os.File, exclusive bool) error {
+	return nil
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package blinktree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an advisory lock on the btree file, exclusive for
+// read-write access and shared for read-only access. It fails with
+// ErrLocked instead of waiting when another open file holds the lock.
+// The lock is released when the file is closed.
+func lockFile(file *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	for {
+		err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, syscall.EINTR):
+			continue
+		case errors.Is(err, syscall.EWOULDBLOCK):
+			return fmt.Errorf("%w: %s", ErrLocked, file.Name())
+		default:
+			return fmt.Errorf("blinktree: unable to lock btree file: %w", err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+//go:build unix
+
+package blinktree
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTree_fileLock(t *testing.T) {
+	name := "data/tree_file_lock.db"
+	removeTree(name)
+	writer, err := Open(name, Options{})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+
+	for _, opts := range []Options{{}, {ReadOnly: true}} {
+		if _, err := Open(name, opts); !errors.Is(err, ErrLocked) {
+			t.Errorf("Open(ReadOnly: %v) with writer err = %v, want %v", opts.ReadOnly, err, ErrLocked)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close() err = %v", err)
+	}
+
+	// readers share the file, a writer waits for all of them to close
+	var readers []*Tree
+	for i := 0; i < 2; i++ {
+		reader, err := Open(name, Options{ReadOnly: true})
+		if err != nil {
+			t.Fatalf("Open() reader %d err = %v", i, err)
+		}
+		readers = append(readers, reader)
+	}
+	for _, reader := range readers {
+		if _, err := Open(name, Options{}); !errors.Is(err, ErrLocked) {
+			t.Errorf("Open() with reader err = %v, want %v", err, ErrLocked)
+		}
+		if err := reader.Close(); err != nil {
+			t.Fatalf("Close() err = %v", err)
+		}
+	}
+
+	writer, err = Open(name, Options{})
+	if err != nil {
+		t.Fatalf("Open() after readers closed err = %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close() err = %v", err)
+	}
+}
@@ -0,0 +1,357 @@
+package blinktree
+
+import "bytes"
+
+// IterOptions bounds the keys visited by an Iterator to [Start, End).
+// A nil bound leaves that side of the range open.
+type IterOptions struct {
+	Start []byte // first key visited, inclusive
+	End   []byte // key ending the range, exclusive
+}
+
+// Iterator walks the keys of a Tree in ascending or descending order.
+//
+// Keys are visited in the order they are stored: the duplicates of a
+// key follow it ordered by id, so a key extending a duplicated key by
+// a few bytes may be visited before or among its duplicates.
+//
+// The iterator reads one leaf page at a time into its cursor, so it
+// observes updates made to pages it has not reached yet. It must not be
+// used concurrently and must be released with Close.
+type Iterator struct {
+	t     *Tree
+	tree  *BLTree // handle owning the cursor page
+	opts  IterOptions
+	slot  uint32 // current slot in tree.cursor
+	raw   []byte // current key as stored, including a duplicate suffix
+	last  []byte // stored keys after it are past End, see endLast
+	key   []byte
+	value []byte
+	valid bool
+	err   error
+}
+
+// NewIterator returns an unpositioned iterator over the keys in opts,
+// call Seek to position it
+func (t *Tree) NewIterator(opts IterOptions) *Iterator {
+	return &Iterator{t: t, tree: t.handle(), opts: opts}
+}
+
+// Scan calls fn for each key in [start, end) in ascending order
+// until fn returns false
+func (t *Tree) Scan(start, end []byte, fn func(key, value []byte) bool) error {
+	return scan(t.NewIterator(IterOptions{Start: start, End: end}), fn)
+}
+
+// scan calls fn for each key of the iterator range and closes it
+func scan(it *Iterator, fn func(key, value []byte) bool) error {
+	defer it.Close()
+
+	for it.Seek(nil); it.Valid(); it.Next() {
+		if !fn(it.Key(), it.Value()) {
+			break
+		}
+	}
+	return it.Err()
+}
+
+// NewPrefixIterator returns an unpositioned iterator over the keys
+// starting with prefix
+func (t *Tree) NewPrefixIterator(prefix []byte) *Iterator {
+	return t.NewIterator(IterOptions{Start: prefix, End: prefixEnd(prefix)})
+}
+
+// ScanPrefix calls fn for each key starting with prefix in ascending
+// order until fn returns false
+func (t *Tree) ScanPrefix(prefix []byte, fn func(key, value []byte) bool) error {
+	return t.Scan(prefix, prefixEnd(prefix), fn)
+}
+
+// prefixEnd returns the first key after all keys starting with prefix,
+// or nil when no such key exists
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// Seek positions the iterator at the first key at or after key.
+// Seek(nil) positions it at the first key of the range.
+func (it *Iterator) Seek(key []byte) {
+	if it.tree == nil {
+		return
+	}
+	if it.opts.Start != nil && bytes.Compare(key, it.opts.Start) < 0 {
+		key = it.opts.Start
+	}
+
+	it.err = nil
+	it.last = nil
+	it.t.mu.RLock()
+	it.tree.err = nil
+	it.slot = it.tree.startKey(key)
+	it.t.mu.RUnlock()
+
+	it.settle(key)
+}
+
+// Next moves the iterator to the following key
+func (it *Iterator) Next() {
+	if !it.valid {
+		return
+	}
+
+	it.t.mu.RLock()
+	it.slot = it.tree.nextKey(it.slot)
+	it.t.mu.RUnlock()
+
+	it.settle(nil)
+}
+
+// SeekLast positions the iterator at the last key of the range
+func (it *Iterator) SeekLast() {
+	if it.opts.End != nil {
+		it.seekBefore(it.opts.End, false)
+	} else {
+		it.seekBefore([]byte{0xff, 0xff}, false) // the stopper key
+	}
+}
+
+// SeekForPrev positions the iterator at the last key at or before key
+func (it *Iterator) SeekForPrev(key []byte) {
+	if it.opts.End != nil && bytes.Compare(key, it.opts.End) >= 0 {
+		it.seekBefore(it.opts.End, false)
+	} else {
+		it.seekBefore(key, true)
+	}
+}
+
+// Prev moves the iterator to the preceding key
+func (it *Iterator) Prev() {
+	if !it.valid {
+		return
+	}
+
+	// look for a live key on the cached page first
+	cursor := it.tree.cursor
+	for slot := it.slot - 1; slot > 0; slot-- {
+		if !cursor.Dead(slot) && cursor.Typ(slot) != Librarian {
+			it.slot = slot
+			it.settlePrev()
+			return
+		}
+	}
+
+	raw := it.raw
+	it.err = nil
+	it.t.mu.RLock()
+	it.tree.err = nil
+	it.slot = it.tree.prevEntry(raw)
+	it.t.mu.RUnlock()
+
+	it.settlePrev()
+}
+
+func (it *Iterator) seekBefore(key []byte, inclusive bool) {
+	if it.tree == nil {
+		return
+	}
+
+	it.err = nil
+	it.t.mu.RLock()
+	it.tree.err = nil
+	it.slot = it.tree.prevKey(key, inclusive)
+	it.t.mu.RUnlock()
+
+	it.settlePrev()
+}
+
+// settlePrev loads the key at the current slot found by prevKey,
+// moving back past keys out of the range and overflow values removed
+// since the page was cached
+func (it *Iterator) settlePrev() {
+	it.valid = false
+	it.raw, it.key, it.value = nil, nil, nil
+
+	for {
+		if it.tree.err != nil {
+			it.err = it.tree.err
+			return
+		}
+		if it.released() || it.slot == 0 {
+			return
+		}
+
+		cursor := it.tree.cursor
+		raw := cursor.Key(it.slot)
+		key := raw
+		if cursor.Typ(it.slot) == Duplicate {
+			key = key[:len(key)-BtId]
+		}
+		// keys before start are only stored before it, a duplicate
+		// of such a key may still follow a key of the range
+		if it.opts.Start != nil && bytes.Compare(raw, it.opts.Start) < 0 {
+			return
+		}
+
+		if it.inRange(key) {
+			if found := it.load(raw, key, cursor); found || it.err != nil {
+				it.valid = found && !it.released()
+				return
+			}
+		}
+
+		raw = append([]byte(nil), raw...)
+		it.t.mu.RLock()
+		it.slot = it.tree.prevEntry(raw)
+		it.t.mu.RUnlock()
+	}
+}
+
+// settle moves the cursor from the current slot to the first live key
+// of the range at or after floor, skipping dead and librarian slots and
+// the stopper key
+func (it *Iterator) settle(floor []byte) {
+	it.valid = false
+	it.raw, it.key, it.value = nil, nil, nil
+
+	for {
+		if it.tree.err != nil {
+			it.err = it.tree.err
+			return
+		}
+		if it.released() || it.slot == 0 {
+			return
+		}
+
+		cursor := it.tree.cursor
+		stopper := it.slot == cursor.Cnt && GetID(&cursor.Right) == 0
+		if !stopper && !cursor.Dead(it.slot) && cursor.Typ(it.slot) != Librarian {
+			raw := cursor.Key(it.slot)
+			key := raw
+			if cursor.Typ(it.slot) == Duplicate {
+				key = key[:len(key)-BtId]
+			}
+			if it.opts.End != nil && bytes.Compare(raw, it.endLast()) > 0 {
+				return
+			}
+
+			if bytes.Compare(key, floor) >= 0 && it.inRange(key) {
+				if found := it.load(raw, key, cursor); found || it.err != nil {
+					it.valid = found && !it.released()
+					return
+				}
+			}
+		}
+
+		it.t.mu.RLock()
+		it.slot = it.tree.nextKey(it.slot)
+		it.t.mu.RUnlock()
+	}
+}
+
+// endLast returns the last stored key that may still lie before End:
+// End itself, or a prefix of End followed by a duplicate id
+func (it *Iterator) endLast() []byte {
+	if it.last != nil {
+		return it.last
+	}
+
+	end := it.opts.End
+	it.last = end
+	dup := it.t.mgr.lastDup()
+	for i := 0; i < len(end); i++ {
+		if last := dupKey(end[:i], dup); bytes.Compare(last, it.last) > 0 {
+			it.last = last
+		}
+	}
+	return it.last
+}
+
+// released reports, setting the error, whether the iterator reads
+// a snapshot that was released
+func (it *Iterator) released() bool {
+	if !it.tree.snap.isReleased() {
+		return false
+	}
+	it.err = ErrSnapshotReleased
+	return true
+}
+
+// inRange reports whether key, without a duplicate suffix,
+// lies within the iterator bounds
+func (it *Iterator) inRange(key []byte) bool {
+	if it.opts.Start != nil && bytes.Compare(key, it.opts.Start) < 0 {
+		return false
+	}
+	return it.opts.End == nil || bytes.Compare(key, it.opts.End) < 0
+}
+
+// load copies the key and value at the current slot, reporting false
+// when an overflow value was removed after the page was cached
+func (it *Iterator) load(raw, key []byte, cursor *Page) bool {
+	it.raw = append([]byte(nil), raw...)
+	it.key = append([]byte(nil), key...)
+
+	if !cursor.Overflow(it.slot) {
+		it.value = append([]byte(nil), *cursor.Value(it.slot)...)
+		return true
+	}
+
+	// the overflow chain may have been released since the page
+	// was cached, read the value again under the leaf page lock
+	it.t.mu.RLock()
+	found, value, err := it.tree.findRaw(raw, it.t.opts.MaxValueSize)
+	it.t.mu.RUnlock()
+	if err != nil {
+		it.err = err
+		return false
+	}
+
+	it.value = value
+	return found
+}
+
+// Valid reports whether the iterator is positioned at a key
+func (it *Iterator) Valid() bool {
+	return it.valid
+}
+
+// Key returns a copy of the current key
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+// DupID returns the unique id of the current key when it was stored
+// with PutDup, or 0 for a unique key
+func (it *Iterator) DupID() uint64 {
+	if len(it.raw) == len(it.key) {
+		return 0
+	}
+	return uint64(GetID((*[BtId]byte)(it.raw[len(it.key):])))
+}
+
+// Value returns a copy of the value of the current key
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the iterator
+func (it *Iterator) Close() error {
+	if it.tree != nil {
+		it.t.release(it.tree)
+		it.tree = nil
+	}
+	it.valid = false
+	return nil
+}
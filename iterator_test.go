@@ -0,0 +1,467 @@
+package blinktree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestIterator(t *testing.T) {
+	_ = os.Remove("data/iterator.db")
+	tree, err := Open("data/iterator.db", Options{PoolSize: 32, Durability: DurabilityNone})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	// spread keys over many leaf pages, leaving every third key deleted
+	num := uint64(3000)
+	for i := uint64(0); i < num; i++ {
+		if err := tree.Put(keyOf(i), keyOf(i)[4:]); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+	for i := uint64(0); i < num; i += 3 {
+		if err := tree.Delete(keyOf(i)); err != nil {
+			t.Fatalf("Delete() err = %v", err)
+		}
+	}
+	large := bytes.Repeat([]byte{0x5a}, 3*int(tree.mgr.pageDataSize))
+	if err := tree.Put(keyOf(1000), large); err != nil {
+		t.Fatalf("Put() err = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		opts      IterOptions
+		seek      []byte
+		wantFirst uint64
+		wantEnd   uint64
+	}{
+		{name: "full scan", opts: IterOptions{}, seek: nil, wantFirst: 1, wantEnd: num},
+		{name: "seek to deleted key", opts: IterOptions{}, seek: keyOf(300), wantFirst: 301, wantEnd: num},
+		{name: "bounded", opts: IterOptions{Start: keyOf(100), End: keyOf(2000)}, seek: nil, wantFirst: 100, wantEnd: 2000},
+		{name: "seek before start", opts: IterOptions{Start: keyOf(500)}, seek: keyOf(5), wantFirst: 500, wantEnd: num},
+		{name: "end only", opts: IterOptions{End: keyOf(10)}, seek: nil, wantFirst: 1, wantEnd: 10},
+		{name: "empty range", opts: IterOptions{Start: keyOf(7), End: keyOf(7)}, seek: nil, wantFirst: 7, wantEnd: 7},
+		{name: "past last key", opts: IterOptions{}, seek: keyOf(num), wantFirst: num, wantEnd: num},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it := tree.NewIterator(tt.opts)
+			defer it.Close()
+
+			want := tt.wantFirst
+			for it.Seek(tt.seek); it.Valid(); it.Next() {
+				for want%3 == 0 {
+					want++
+				}
+				if want >= tt.wantEnd {
+					t.Fatalf("Key() = %v past the end of the range", it.Key())
+				}
+				if !bytes.Equal(it.Key(), keyOf(want)) {
+					t.Fatalf("Key() = %v, want %v", it.Key(), keyOf(want))
+				}
+				wantValue := keyOf(want)[4:]
+				if want == 1000 {
+					wantValue = large
+				}
+				if !bytes.Equal(it.Value(), wantValue) {
+					t.Fatalf("Value() of %v = %d bytes, want %d", it.Key(), len(it.Value()), len(wantValue))
+				}
+				want++
+			}
+			for want < tt.wantEnd && want%3 == 0 {
+				want++
+			}
+			if it.Err() != nil {
+				t.Errorf("Err() = %v", it.Err())
+			}
+			if want < tt.wantEnd {
+				t.Errorf("iteration stopped before %v, want end at %v", want, tt.wantEnd)
+			}
+		})
+	}
+
+	t.Run("scan stops early", func(t *testing.T) {
+		var keys [][]byte
+		err := tree.Scan(keyOf(10), nil, func(key, value []byte) bool {
+			keys = append(keys, key)
+			return len(keys) < 4
+		})
+		if err != nil {
+			t.Fatalf("Scan() err = %v", err)
+		}
+		want := [][]byte{keyOf(10), keyOf(11), keyOf(13), keyOf(14)}
+		if len(keys) != len(want) {
+			t.Fatalf("Scan() visited %d keys, want %d", len(keys), len(want))
+		}
+		for i := range want {
+			if !bytes.Equal(keys[i], want[i]) {
+				t.Errorf("Scan() key %d = %v, want %v", i, keys[i], want[i])
+			}
+		}
+	})
+}
+
+func TestIterator_emptyTree(t *testing.T) {
+	_ = os.Remove("data/iterator_empty.db")
+	tree, err := Open("data/iterator_empty.db", Options{})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	it := tree.NewIterator(IterOptions{})
+	defer it.Close()
+	if it.Seek(nil); it.Valid() {
+		t.Errorf("Valid() = true on empty tree, key %v", it.Key())
+	}
+	if it.Err() != nil {
+		t.Errorf("Err() = %v", it.Err())
+	}
+}
+
+func TestIterator_reverse(t *testing.T) {
+	_ = os.Remove("data/iterator_reverse.db")
+	tree, err := Open("data/iterator_reverse.db", Options{PoolSize: 32, Durability: DurabilityNone})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	keyOf := func(i uint64) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		return bs
+	}
+
+	// leave every third key and whole leaf pages in [1000, 1600) deleted
+	num := uint64(3000)
+	live := func(i uint64) bool {
+		return i%3 != 0 && (i < 1000 || i >= 1600)
+	}
+	for i := uint64(0); i < num; i++ {
+		if err := tree.Put(keyOf(i), keyOf(i)[4:]); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+	for i := uint64(0); i < num; i++ {
+		if !live(i) {
+			if err := tree.Delete(keyOf(i)); err != nil {
+				t.Fatalf("Delete() err = %v", err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name      string
+		opts      IterOptions
+		seek      func(it *Iterator)
+		wantFirst uint64 // first key expected, walking down
+		wantEnd   uint64 // lowest key of the range
+	}{
+		{name: "seek last", seek: func(it *Iterator) { it.SeekLast() }, wantFirst: num - 1, wantEnd: 0},
+		{name: "seek last bounded", opts: IterOptions{Start: keyOf(500), End: keyOf(2500)}, seek: func(it *Iterator) { it.SeekLast() }, wantFirst: 2499, wantEnd: 500},
+		{name: "seek for prev existing", seek: func(it *Iterator) { it.SeekForPrev(keyOf(1700)) }, wantFirst: 1700, wantEnd: 0},
+		{name: "seek for prev deleted", seek: func(it *Iterator) { it.SeekForPrev(keyOf(1602)) }, wantFirst: 1601, wantEnd: 0},
+		{name: "seek for prev past end", opts: IterOptions{End: keyOf(20)}, seek: func(it *Iterator) { it.SeekForPrev(keyOf(100)) }, wantFirst: 19, wantEnd: 0},
+		{name: "seek for prev before start", opts: IterOptions{Start: keyOf(20)}, seek: func(it *Iterator) { it.SeekForPrev(keyOf(5)) }, wantFirst: 0, wantEnd: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it := tree.NewIterator(tt.opts)
+			defer it.Close()
+
+			want := int64(tt.wantFirst)
+			for tt.seek(it); it.Valid(); it.Prev() {
+				for want >= 0 && !live(uint64(want)) {
+					want--
+				}
+				if want < int64(tt.wantEnd) {
+					t.Fatalf("Key() = %v past the start of the range", it.Key())
+				}
+				if !bytes.Equal(it.Key(), keyOf(uint64(want))) {
+					t.Fatalf("Key() = %v, want %v", it.Key(), keyOf(uint64(want)))
+				}
+				if !bytes.Equal(it.Value(), keyOf(uint64(want))[4:]) {
+					t.Fatalf("Value() = %v, want %v", it.Value(), keyOf(uint64(want))[4:])
+				}
+				want--
+			}
+			for want >= int64(tt.wantEnd) && !live(uint64(want)) {
+				want--
+			}
+			if it.Err() != nil {
+				t.Errorf("Err() = %v", it.Err())
+			}
+			if want >= int64(tt.wantEnd) {
+				t.Errorf("iteration stopped before %v, want end at %v", want, tt.wantEnd)
+			}
+		})
+	}
+
+	t.Run("change direction", func(t *testing.T) {
+		it := tree.NewIterator(IterOptions{})
+		defer it.Close()
+
+		steps := []struct {
+			move func()
+			want uint64
+		}{
+			{move: func() { it.Seek(keyOf(1599)) }, want: 1600},
+			{move: it.Prev, want: 998},
+			{move: it.Prev, want: 997},
+			{move: it.Next, want: 998},
+			{move: it.Next, want: 1600},
+			{move: it.Next, want: 1601},
+			{move: it.Prev, want: 1600},
+			{move: it.Prev, want: 998},
+		}
+		for i, step := range steps {
+			step.move()
+			if !it.Valid() || !bytes.Equal(it.Key(), keyOf(step.want)) {
+				t.Fatalf("step %d: Key() = %v, %v, want %v", i, it.Key(), it.Err(), keyOf(step.want))
+			}
+		}
+	})
+}
+
+func TestIterator_reverseDuplicates(t *testing.T) {
+	tree, err := Open("", Options{InMemory: true, PageBits: 12})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	// "a" has duplicates on a single page, "m" spans many pages;
+	// "a\x00" is stored before the duplicates of "a"
+	for _, value := range []string{"a1", "a2", "a3"} {
+		if _, err := tree.PutDup([]byte("a"), []byte(value)); err != nil {
+			t.Fatalf("PutDup() err = %v", err)
+		}
+	}
+	for _, key := range []string{"0", "a\x00", "b"} {
+		if err := tree.Put([]byte(key), []byte(key)); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+	num := 2000
+	for i := 0; i < num; i++ {
+		if _, err := tree.PutDup([]byte("m"), binary.BigEndian.AppendUint32(nil, uint32(i))); err != nil {
+			t.Fatalf("PutDup() err = %v", err)
+		}
+	}
+
+	walk := func(opts IterOptions, seek func(it *Iterator), max int) []string {
+		it := tree.NewIterator(opts)
+		defer it.Close()
+
+		var got []string
+		for seek(it); it.Valid() && len(got) < max; it.Prev() {
+			got = append(got, string(it.Key())+"="+string(it.Value()))
+		}
+		if it.Err() != nil {
+			t.Fatalf("Err() = %v", it.Err())
+		}
+		return got
+	}
+
+	tests := []struct {
+		name string
+		opts IterOptions
+		seek func(it *Iterator)
+		want []string
+	}{
+		{name: "seek for prev duplicate", seek: func(it *Iterator) { it.SeekForPrev([]byte("a")) }, want: []string{"a=a3", "a=a2", "a=a1", "a\x00=a\x00", "0=0"}},
+		{name: "seek for prev after duplicates", seek: func(it *Iterator) { it.SeekForPrev([]byte("a\x00")) }, want: []string{"a=a3", "a=a2", "a=a1", "a\x00=a\x00", "0=0"}},
+		{name: "seek for prev between", seek: func(it *Iterator) { it.SeekForPrev([]byte("a\x00\x00")) }, want: []string{"a=a3", "a=a2", "a=a1", "a\x00=a\x00", "0=0"}},
+		{name: "seek for prev before duplicates", seek: func(it *Iterator) { it.SeekForPrev([]byte("0\x00")) }, want: []string{"0=0"}},
+		{name: "seek last before extended key", opts: IterOptions{End: []byte("a\x00")}, seek: func(it *Iterator) { it.SeekLast() }, want: []string{"a=a3", "a=a2", "a=a1", "0=0"}},
+		{name: "seek last bounded", opts: IterOptions{Start: []byte("a"), End: []byte("b")}, seek: func(it *Iterator) { it.SeekLast() }, want: []string{"a=a3", "a=a2", "a=a1", "a\x00=a\x00"}},
+		{name: "seek last after duplicates", opts: IterOptions{Start: []byte("a\x00"), End: []byte("b\x00")}, seek: func(it *Iterator) { it.SeekLast() }, want: []string{"b=b", "a\x00=a\x00"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := walk(tt.opts, tt.seek, 10); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+				t.Errorf("keys = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("duplicates across pages", func(t *testing.T) {
+		got := walk(IterOptions{Start: []byte("m")}, func(it *Iterator) { it.SeekForPrev([]byte("m")) }, num+1)
+		if len(got) != num {
+			t.Fatalf("walked %d duplicates, want %d", len(got), num)
+		}
+		for i, kv := range got {
+			want := "m=" + string(binary.BigEndian.AppendUint32(nil, uint32(num-1-i)))
+			if kv != want {
+				t.Fatalf("duplicate %d = %q, want %q", i, kv, want)
+			}
+		}
+	})
+}
+
+func TestTree_ScanPrefix(t *testing.T) {
+	_ = os.Remove("data/scan_prefix.db")
+	tree, err := Open("data/scan_prefix.db", Options{Durability: DurabilityNone})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	keys := []string{
+		"a|1", "tenant10|alice|001", "tenant1|alice|001", "tenant1|alice|002",
+		"tenant1|bob|001", "tenant1|bob|002", "tenant2|alice|001",
+		"\xfe\xff", "\xff\x01", "\xff\xfe",
+	}
+	for _, key := range keys {
+		if err := tree.Put([]byte(key), []byte("v:"+key)); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "tenant1|", want: []string{"tenant1|alice|001", "tenant1|alice|002", "tenant1|bob|001", "tenant1|bob|002"}},
+		{prefix: "tenant1|bob|", want: []string{"tenant1|bob|001", "tenant1|bob|002"}},
+		{prefix: "tenant1", want: []string{"tenant10|alice|001", "tenant1|alice|001", "tenant1|alice|002", "tenant1|bob|001", "tenant1|bob|002"}},
+		{prefix: "tenant3", want: nil},
+		{prefix: "\xff", want: []string{"\xff\x01", "\xff\xfe"}},
+		{prefix: "", want: keys},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			var got []string
+			err := tree.ScanPrefix([]byte(tt.prefix), func(key, value []byte) bool {
+				if string(value) != "v:"+string(key) {
+					t.Errorf("value of %q = %q", key, value)
+				}
+				got = append(got, string(key))
+				return true
+			})
+			if err != nil {
+				t.Fatalf("ScanPrefix() err = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ScanPrefix() = %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("ScanPrefix() = %q, want %q", got, tt.want)
+				}
+			}
+
+			it := tree.NewPrefixIterator([]byte(tt.prefix))
+			defer it.Close()
+			n := 0
+			for it.SeekLast(); it.Valid(); it.Prev() {
+				n++
+				if want := tt.want[len(tt.want)-n]; string(it.Key()) != want {
+					t.Errorf("reverse Key() = %q, want %q", it.Key(), want)
+				}
+			}
+			if n != len(tt.want) {
+				t.Errorf("reverse iteration visited %d keys, want %d", n, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestTree_Scan_duplicates(t *testing.T) {
+	tree, err := Open("", Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	// stored as "a\x00", "a" dups, "a\x00\x01" dup, "a\x01", "b" dups
+	for _, kv := range [][2]string{{"a", "a1"}, {"a", "a2"}, {"a\x00\x01", "x"}, {"b", "b1"}, {"b", "b2"}} {
+		if _, err := tree.PutDup([]byte(kv[0]), []byte(kv[1])); err != nil {
+			t.Fatalf("PutDup() err = %v", err)
+		}
+	}
+	for _, kv := range [][2]string{{"a\x00", "u"}, {"a\x01", "v"}} {
+		if err := tree.Put([]byte(kv[0]), []byte(kv[1])); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts IterOptions
+		seek []byte
+		want []string
+	}{
+		{name: "prefix after duplicated key", opts: IterOptions{Start: []byte("a\x00"), End: prefixEnd([]byte("a\x00"))}, want: []string{"a\x00=u", "a\x00\x01=x"}},
+		{name: "prefix of duplicated key", opts: IterOptions{Start: []byte("a"), End: prefixEnd([]byte("a"))}, want: []string{"a\x00=u", "a=a1", "a=a2", "a\x00\x01=x", "a\x01=v"}},
+		{name: "end after duplicated key", opts: IterOptions{End: []byte("a\x00")}, want: []string{"a=a1", "a=a2"}},
+		{name: "start after duplicated key", opts: IterOptions{Start: []byte("a\x00"), End: []byte("b")}, want: []string{"a\x00=u", "a\x00\x01=x", "a\x01=v"}},
+		{name: "seek past duplicated key", seek: []byte("a\x00\x00"), want: []string{"a\x00\x01=x", "a\x01=v", "b=b1", "b=b2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it := tree.NewIterator(tt.opts)
+			defer it.Close()
+
+			var got []string
+			for it.Seek(tt.seek); it.Valid(); it.Next() {
+				got = append(got, string(it.Key())+"="+string(it.Value()))
+			}
+			if it.Err() != nil {
+				t.Fatalf("Err() = %v", it.Err())
+			}
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+				t.Errorf("keys = %q, want %q", got, tt.want)
+			}
+			if tt.seek != nil {
+				return
+			}
+
+			var back []string
+			for it.SeekLast(); it.Valid(); it.Prev() {
+				back = append([]string{string(it.Key()) + "=" + string(it.Value())}, back...)
+			}
+			if fmt.Sprintf("%q", back) != fmt.Sprintf("%q", tt.want) {
+				t.Errorf("reverse keys = %q, want %q", back, tt.want)
+			}
+		})
+	}
+
+	var got []string
+	err = tree.ScanPrefix([]byte("a\x00"), func(key, value []byte) bool {
+		got = append(got, string(key))
+		return true
+	})
+	if err != nil || fmt.Sprintf("%q", got) != fmt.Sprintf("%q", []string{"a\x00", "a\x00\x01"}) {
+		t.Errorf("ScanPrefix() = %q, %v, want keys with the prefix only", got, err)
+	}
+}
+
+func Test_prefixEnd(t *testing.T) {
+	tests := []struct {
+		prefix []byte
+		want   []byte
+	}{
+		{prefix: []byte("abc"), want: []byte("abd")},
+		{prefix: []byte{0x01, 0xff}, want: []byte{0x02}},
+		{prefix: []byte{0xff, 0xff}, want: nil},
+		{prefix: nil, want: nil},
+	}
+	for _, tt := range tests {
+		if got := prefixEnd(tt.prefix); !bytes.Equal(got, tt.want) || (got == nil) != (tt.want == nil) {
+			t.Errorf("prefixEnd(%v) = %v, want %v", tt.prefix, got, tt.want)
+		}
+	}
+}
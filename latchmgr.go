@@ -1,4 +1,4 @@
-package main
+package blinktree
 
 import (
 	"runtime"
@@ -7,7 +7,7 @@ import (
 )
 
 /*
- *    There are six lock types for each node in four independent sets:
+ *    There are five lock types for each node in three independent sets:
  *    Set 1
  *        1. AccessIntent: Sharable.
  *               Going to Read the node. Incompatible with NodeDelete.
@@ -21,9 +21,10 @@ import (
  *    Set 3
  *        5. ParentModification: Exclusive.
  *               Change the node's parent keys. Incompatible with ParentModification.
- *    Set 4
- *        6. AtomicModification: Exclusive.
- *               Atomic Update including node is underway. Incompatible with AtomicModification.
+ *
+ *    The C implementation has a fourth set for atomic updates spanning several
+ *    nodes. This golang implementation has none: a Batch is applied while
+ *    holding the tree's commit lock exclusively, see Tree.Write.
  */
 
 type BLTLockMode int
@@ -35,7 +36,6 @@ const (
 	LockRead   BLTLockMode = 4
 	LockWrite  BLTLockMode = 8
 	LockParent BLTLockMode = 16
-	//LockAtomic BLTLockMode = 32 // Note: not supported in this golang implementation
 )
 
 const (
@@ -74,30 +74,32 @@ type (
 		readWr BLTRWLock // read / write page lock
 		access BLTRWLock // access intent / page delete
 		parent BLTRWLock // posting of fence key in parent
-		atomic BLTRWLock // atomic update in progress
-		split  uint      // right split page atomic insert
 		entry  uint      // entry slot in latch table
 		next   uint      // next entry in hash table chain
 		prev   uint      // prev entry in hash table chain
 		pin    uint32    // number of outstanding threads
 		dirty  bool      // page in cache is dirty
-
-		atomicID uint // thread id holding atomic lock
+		failed bool      // page in cache failed to load
 	}
 )
 
-func (lock *BLTRWLock) WriteLock() {
+// WriteLock takes the lock exclusively,
+// reporting whether it had to wait for it
+func (lock *BLTRWLock) WriteLock() (waited bool) {
 	tix := atomic.AddUint32(&lock.ticket, 1) - 1
 
 	// wait for our ticket to come up
 	for tix != lock.serving {
+		waited = true
 		runtime.Gosched()
 	}
 	w := Pres | (tix & PhID)
 	r := atomic.AddUint32(&lock.rin, w) - w
 	for r != lock.rout {
+		waited = true
 		runtime.Gosched()
 	}
+	return waited
 }
 
 func (lock *BLTRWLock) WriteRelease() {
@@ -105,13 +107,17 @@ func (lock *BLTRWLock) WriteRelease() {
 	lock.serving++
 }
 
-func (lock *BLTRWLock) ReadLock() {
+// ReadLock takes the lock shared,
+// reporting whether it had to wait for it
+func (lock *BLTRWLock) ReadLock() (waited bool) {
 	w := (atomic.AddUint32(&lock.rin, RInc) - RInc) & Mask
 	if w > 0 {
 		for w == lock.rin&Mask {
+			waited = true
 			runtime.Gosched()
 		}
 	}
+	return waited
 }
 
 func (lock *BLTRWLock) ReadRelease() {
@@ -0,0 +1,89 @@
+package blinktree
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// metric is a value of Stats exposed by the metrics handler
+type metric struct {
+	name  string
+	typ   string // counter or gauge
+	help  string
+	value func(s *Stats) float64
+}
+
+var metrics = []metric{
+	{"blinktree_pages", "gauge", "Pages allocated in the tree file.",
+		func(s *Stats) float64 { return float64(s.Pages) }},
+	{"blinktree_pool_size_pages", "gauge", "Pages the buffer pool can hold.",
+		func(s *Stats) float64 { return float64(s.PoolSize) }},
+	{"blinktree_pool_hits_total", "counter", "Page pins finding the page in the buffer pool.",
+		func(s *Stats) float64 { return float64(s.PoolHits) }},
+	{"blinktree_pool_misses_total", "counter", "Page pins reading the page from storage.",
+		func(s *Stats) float64 { return float64(s.PoolMisses) }},
+	{"blinktree_pool_evictions_total", "counter", "Buffer pool entries taken over by another page.",
+		func(s *Stats) float64 { return float64(s.PoolEvictions) }},
+	{"blinktree_page_reads_total", "counter", "Pages read from the log or the tree file.",
+		func(s *Stats) float64 { return float64(s.PageReads) }},
+	{"blinktree_page_writes_total", "counter", "Pages appended to the log.",
+		func(s *Stats) float64 { return float64(s.PageWrites) }},
+	{"blinktree_checkpoints_total", "counter", "Checkpoints copying the log into the tree file.",
+		func(s *Stats) float64 { return float64(s.Checkpoints) }},
+	{"blinktree_latch_waits_total", "counter", "Page locks that were not granted at once.",
+		func(s *Stats) float64 { return float64(s.LatchWaits) }},
+	{"blinktree_log_frames", "gauge", "Pages in the log since the last checkpoint.",
+		func(s *Stats) float64 { return float64(s.LogFrames) }},
+}
+
+// shapeMetrics are the values of Stats found by walking the tree
+var shapeMetrics = []metric{
+	{"blinktree_height", "gauge", "Number of levels of the tree, leaves included.",
+		func(s *Stats) float64 { return float64(s.Height) }},
+	{"blinktree_free_pages", "gauge", "Pages on the free chain.",
+		func(s *Stats) float64 { return float64(s.FreePages) }},
+	{"blinktree_fill_factor", "gauge", "Share of the page data area used by live keys.",
+		func(s *Stats) float64 { return s.FillFactor }},
+	{"blinktree_garbage_bytes", "gauge", "Bytes of deleted keys and values not reclaimed yet.",
+		func(s *Stats) float64 { return float64(s.GarbageBytes) }},
+	{"blinktree_pool_dirty_pages", "gauge", "Buffer pool pages updated and not logged yet.",
+		func(s *Stats) float64 { return float64(s.DirtyPages) }},
+}
+
+// MetricsHandler returns a handler serving the Stats of t in the
+// Prometheus text exposition format. Requests do not wait for the
+// operations on the tree: the buffer pool and log counters are read as
+// they are, the shape of the tree comes from the last Stats, which are
+// walked again in the background once they are a minute old. The shape
+// is left out until the first walk is done.
+func MetricsHandler(t *Tree) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		writeMetrics(&buf, metrics, t.mgr.counters())
+		if shape, at := t.cachedStats(); !at.IsZero() {
+			writeMetrics(&buf, shapeMetrics, &shape)
+			writeLevelPages(&buf, &shape)
+
+			name := "blinktree_stats_age_seconds"
+			fmt.Fprintf(&buf, "# HELP %s Seconds since the tree was walked for its shape.\n# TYPE %s gauge\n%s %g\n", name, name, name, time.Since(at).Seconds())
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write(buf.Bytes())
+	})
+}
+
+func writeMetrics(buf *bytes.Buffer, metrics []metric, s *Stats) {
+	for _, m := range metrics {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", m.name, m.help, m.name, m.typ, m.name, m.value(s))
+	}
+}
+
+func writeLevelPages(buf *bytes.Buffer, s *Stats) {
+	name := "blinktree_level_pages"
+	fmt.Fprintf(buf, "# HELP %s Pages of each level of the tree, leaves at level 0.\n# TYPE %s gauge\n", name, name)
+	for lvl, pages := range s.LevelPages {
+		fmt.Fprintf(buf, "%s{level=\"%d\"} %d\n", name, lvl, pages)
+	}
+}
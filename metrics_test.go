@@ -0,0 +1,109 @@
+package blinktree
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	tree := newCheckTree(t)
+	defer tree.Close()
+
+	s, err := tree.Stats()
+	if err != nil {
+		t.Fatalf("Stats() err = %v", err)
+	}
+
+	// scrapes do not wait for the operations on the tree
+	tree.mu.Lock()
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		MetricsHandler(tree).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("metrics handler waits for the tree lock")
+	}
+	tree.mu.Unlock()
+
+	res := rec.Result()
+	if res.StatusCode != http.StatusOK || !strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain") {
+		t.Fatalf("status %d, content type %q, want %d text/plain", res.StatusCode, res.Header.Get("Content-Type"), http.StatusOK)
+	}
+	body, _ := io.ReadAll(res.Body)
+
+	for _, want := range []string{
+		fmt.Sprintf("# TYPE blinktree_pages gauge\nblinktree_pages %d\n", s.Pages),
+		fmt.Sprintf("# TYPE blinktree_pool_size_pages gauge\nblinktree_pool_size_pages %d\n", s.PoolSize),
+		"# TYPE blinktree_pool_hits_total counter\nblinktree_pool_hits_total ",
+		"# TYPE blinktree_latch_waits_total counter\n",
+		"# TYPE blinktree_log_frames gauge\nblinktree_log_frames ",
+		fmt.Sprintf("# TYPE blinktree_height gauge\nblinktree_height %d\n", s.Height),
+		fmt.Sprintf("# TYPE blinktree_free_pages gauge\nblinktree_free_pages %d\n", s.FreePages),
+		fmt.Sprintf("# TYPE blinktree_garbage_bytes gauge\nblinktree_garbage_bytes %d\n", s.GarbageBytes),
+		"# TYPE blinktree_fill_factor gauge\nblinktree_fill_factor 0.",
+		"# TYPE blinktree_pool_dirty_pages gauge\n",
+		fmt.Sprintf("blinktree_level_pages{level=\"0\"} %d\n", s.LevelPages[0]),
+		fmt.Sprintf("blinktree_level_pages{level=\"%d\"} 1\n", s.Height-1),
+		"# TYPE blinktree_stats_age_seconds gauge\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsHandler_concurrent(t *testing.T) {
+	tree, err := Open("", Options{InMemory: true, PageBits: 12})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	// scrapes read the counters while updates checkpoint the log
+	done := make(chan struct{})
+	scraped := make(chan int)
+	go func() {
+		n := 0
+		for {
+			select {
+			case <-done:
+				scraped <- n
+				return
+			default:
+			}
+			rec := httptest.NewRecorder()
+			MetricsHandler(tree).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("status %d, want %d", rec.Code, http.StatusOK)
+			}
+			n++
+		}
+	}()
+
+	for i := 0; i < 20000; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key%06d", i)), []byte("value")); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+		if i%10 == 0 {
+			if err := tree.Sync(); err != nil {
+				t.Fatalf("Sync() err = %v", err)
+			}
+		}
+	}
+	close(done)
+	if n := <-scraped; n == 0 {
+		t.Errorf("no scrape finished")
+	}
+	if s, err := tree.Stats(); err != nil || s.Checkpoints == 0 {
+		t.Errorf("Stats() = %+v, %v, want checkpoints", s, err)
+	}
+}
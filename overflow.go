@@ -0,0 +1,147 @@
+package blinktree
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+ *  Values too large to be kept on a leaf page are written to a chain
+ *  of overflow pages allocated with NewPage. The leaf slot is flagged
+ *  with slotOverflow and its value holds a reference to the chain:
+ *  the total value length followed by the first overflow page number.
+ *
+ *  Each overflow page stores its part of the value at the start of the
+ *  data area, Cnt holds the number of bytes used and Right links to the
+ *  next page of the chain. Overflow pages are only read or released
+ *  while the leaf page that references them is locked.
+ *
+ *  Keys are always stored on the page, limited to MaxKey bytes.
+ */
+
+const overflowRefSize = 4 + BtId // value length and first page number
+
+// maxInline returns the largest value stored directly on a leaf page
+func (mgr *BufMgr) maxInline() int {
+	if limit := int(mgr.pageDataSize / 8); limit < MaxValue {
+		return limit
+	}
+	return MaxValue
+}
+
+// writeOverflow
+//
+// store value on a new chain of overflow pages
+// and return the reference to put in the leaf slot
+func (tree *BLTree) writeOverflow(value []byte) ([]byte, error) {
+	var next uid
+	size := int(tree.mgr.pageDataSize)
+
+	// allocate from the tail so each page can link to its successor
+	for off := (len(value) - 1) / size * size; off >= 0; off -= size {
+		end := off + size
+		if end > len(value) {
+			end = len(value)
+		}
+
+		frame := NewPage(tree.mgr.pageDataSize)
+		frame.Bits = tree.mgr.pageBits
+		frame.Cnt = uint32(end - off)
+		PutID(&frame.Right, next)
+		copy(frame.Data, value[off:end])
+
+		var set PageSet
+		if err := tree.mgr.NewPage(&set, frame, &tree.reads, &tree.writes); err != nil {
+			_ = tree.freeOverflowChain(next)
+			return nil, err
+		}
+		next = set.latch.pageNo
+		tree.mgr.UnpinLatch(set.latch)
+	}
+
+	return overflowRef(len(value), next), nil
+}
+
+// overflowRef returns the reference to an overflow chain
+// holding size bytes from page pageNo
+func overflowRef(size int, pageNo uid) []byte {
+	ref := make([]byte, overflowRefSize)
+	binary.LittleEndian.PutUint32(ref, uint32(size))
+	var id [BtId]byte
+	PutID(&id, pageNo)
+	copy(ref[4:], id[:])
+	return ref
+}
+
+// parseOverflowRef returns the value length and the first
+// page number of the overflow chain given by ref
+func parseOverflowRef(ref []byte) (size int, pageNo uid, err error) {
+	if len(ref) != overflowRefSize {
+		return 0, 0, fmt.Errorf("%w: overflow reference of %d bytes", ErrCorrupt, len(ref))
+	}
+	return int(binary.LittleEndian.Uint32(ref)), GetID((*[BtId]byte)(ref[4:])), nil
+}
+
+// readOverflow
+//
+// reassemble a value from the overflow chain given by ref,
+// the leaf page holding ref must be locked
+func (tree *BLTree) readOverflow(ref []byte) ([]byte, error) {
+	size, pageNo, err := parseOverflowRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	value := make([]byte, 0, size)
+	for pageNo > 0 && len(value) < size {
+		latch, err := tree.mgr.PinLatch(pageNo, true, &tree.reads, &tree.writes)
+		if err != nil {
+			return nil, err
+		}
+		tree.mgr.LockPage(LockRead, latch)
+		page := tree.snap.view(latch, tree.mgr.MapPage(latch))
+		if page.Free || page.Cnt > tree.mgr.pageDataSize {
+			tree.mgr.UnlockPage(LockRead, latch)
+			tree.mgr.UnpinLatch(latch)
+			return nil, fmt.Errorf("%w: bad overflow page %d", ErrCorrupt, pageNo)
+		}
+		value = append(value, page.Data[:page.Cnt]...)
+		pageNo = GetID(&page.Right)
+		tree.mgr.UnlockPage(LockRead, latch)
+		tree.mgr.UnpinLatch(latch)
+	}
+
+	if len(value) != size {
+		return nil, fmt.Errorf("%w: overflow chain holds %d of %d bytes", ErrCorrupt, len(value), size)
+	}
+	return value, nil
+}
+
+// freeOverflow
+//
+// return the overflow chain given by ref to the free list,
+// the leaf page holding ref must be write locked
+func (tree *BLTree) freeOverflow(ref []byte) error {
+	_, pageNo, err := parseOverflowRef(ref)
+	if err != nil {
+		return err
+	}
+	return tree.freeOverflowChain(pageNo)
+}
+
+func (tree *BLTree) freeOverflowChain(pageNo uid) error {
+	for pageNo > 0 {
+		var set PageSet
+		var err error
+		if set.latch, err = tree.mgr.PinLatch(pageNo, true, &tree.reads, &tree.writes); err != nil {
+			return err
+		}
+		set.page = tree.mgr.MapPage(set.latch)
+
+		tree.mgr.LockPage(LockDelete, set.latch)
+		tree.mgr.LockPage(LockWrite, set.latch)
+		pageNo = GetID(&set.page.Right)
+		tree.mgr.FreePage(&set)
+	}
+	return nil
+}
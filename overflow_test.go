@@ -0,0 +1,67 @@
+package blinktree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+func TestTree_overflowValues(t *testing.T) {
+	_ = os.Remove("data/tree_overflow_values.db")
+	tree, err := Open("data/tree_overflow_values.db", Options{PageBits: 12, PoolSize: 32})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	sizes := []int{0, 1, 255, 511, 512, 4096, 4096*3 + 17, 100000}
+	valueOf := func(i, size int) []byte {
+		v := make([]byte, size)
+		for j := range v {
+			v[j] = byte(i + j)
+		}
+		return v
+	}
+	keyOf := func(i int) []byte {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, uint64(i))
+		return bs
+	}
+
+	// store every size, then replace each value with the next size
+	for round := 0; round < len(sizes); round++ {
+		for i := 0; i < 200; i++ {
+			size := sizes[(i+round)%len(sizes)]
+			if err := tree.Put(keyOf(i), valueOf(i, size)); err != nil {
+				t.Fatalf("Put() err = %v", err)
+			}
+		}
+		for i := 0; i < 200; i++ {
+			size := sizes[(i+round)%len(sizes)]
+			if got, err := tree.Get(keyOf(i)); err != nil || !bytes.Equal(got, valueOf(i, size)) {
+				t.Fatalf("round %d Get() = %d bytes, %v, want %d bytes", round, len(got), err, size)
+			}
+		}
+	}
+
+	for i := 0; i < 200; i++ {
+		if err := tree.Delete(keyOf(i)); err != nil {
+			t.Fatalf("Delete() err = %v", err)
+		}
+	}
+	if GetID(tree.mgr.pageZero.Chain()) == 0 {
+		t.Errorf("overflow pages were not returned to the free chain")
+	}
+
+	// storing the same values again must reuse the freed pages
+	allocRight := GetID(tree.mgr.pageZero.AllocRight())
+	for i := 0; i < 200; i++ {
+		if err := tree.Put(keyOf(i), valueOf(i, sizes[i%len(sizes)])); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+	if got := GetID(tree.mgr.pageZero.AllocRight()); got != allocRight {
+		t.Errorf("AllocRight() = %d, want %d", got, allocRight)
+	}
+}
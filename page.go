@@ -1,8 +1,9 @@
-package main
+package blinktree
 
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 )
 
 // SlotType
@@ -24,14 +25,35 @@ const (
 	Delete
 )
 
+func (t SlotType) String() string {
+	switch t {
+	case Unique:
+		return "unique"
+	case Librarian:
+		return "librarian"
+	case Duplicate:
+		return "duplicate"
+	case Delete:
+		return "delete"
+	}
+	return fmt.Sprintf("SlotType(%d)", uint8(t))
+}
+
 const (
 	MaxKey   = 255
 	KeyArray = MaxKey + 1 // 1 is key length
+	MaxValue = 255        // values are prefixed by a one byte length
 
-	PageHeaderSize = 26 // size of page header in bytes
+	PageHeaderSize = 30 // size of page header in bytes
 	SlotSize       = 6  // size of slot in bytes
 )
 
+// slot flag bits, stored in the last byte of a slot
+const (
+	slotDead     = 0x1 // key is deleted
+	slotOverflow = 0x2 // value is a reference to a chain of overflow pages
+)
+
 type (
 	// Slot is page key slot definition
 	Slot struct {
@@ -40,6 +62,7 @@ type (
 		Dead bool     // Keys are marked dead, but remain on the page until
 		// cleanup is called. The fence key (highest key) for
 		// a leaf page is always present, even after cleanup
+		Overflow bool // value is stored on a chain of overflow pages
 	}
 
 	BLTVal struct {
@@ -61,6 +84,7 @@ type (
 		Lvl     uint8       // level of page
 		Kill    bool        // page is being deleted
 		Right   [BtId]uint8 // page number to right
+		Sum     uint32      // checksum of the page as written to disk
 	}
 	Page struct {
 		PageHeader
@@ -113,15 +137,29 @@ func (p *Page) Typ(slot uint32) SlotType {
 func (p *Page) SetDead(slot uint32, b bool) {
 	slotBytes := p.slotBytes(slot)
 	if b {
-		slotBytes[5] = 1
+		slotBytes[5] |= slotDead
 	} else {
-		slotBytes[5] = 0
+		slotBytes[5] &^= slotDead
 	}
 }
 
 func (p *Page) Dead(slot uint32) bool {
 	slotBytes := p.slotBytes(slot)
-	return slotBytes[5] == 1
+	return slotBytes[5]&slotDead > 0
+}
+
+func (p *Page) SetOverflow(slot uint32, b bool) {
+	slotBytes := p.slotBytes(slot)
+	if b {
+		slotBytes[5] |= slotOverflow
+	} else {
+		slotBytes[5] &^= slotOverflow
+	}
+}
+
+func (p *Page) Overflow(slot uint32) bool {
+	slotBytes := p.slotBytes(slot)
+	return slotBytes[5]&slotOverflow > 0
 }
 
 func (p *Page) SetKey(bytes []byte, slot uint32) {
@@ -0,0 +1,181 @@
+package blinktree
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+ *  A snapshot keeps a copy of each page as it was when the snapshot was
+ *  taken. Pages are only modified under a write lock, so the buffer
+ *  manager copies a page into every open snapshot that does not hold it
+ *  yet when the write lock is obtained. Snapshots are taken while no
+ *  update is in progress, so the first copy of a page is its image at
+ *  the time of the snapshot.
+ *
+ *  Snapshot readers lock pages as usual and read the copy when there
+ *  is one. Pages allocated after the snapshot are never reached, and
+ *  pages freed after it were copied when they were write locked to be
+ *  freed. The copies are kept in memory until the snapshot is released.
+ */
+
+// ErrSnapshotReleased is returned when reading a released Snapshot
+var ErrSnapshotReleased = errors.New("blinktree: snapshot released")
+
+// snapshot holds the preimages of the pages modified since it was taken
+type snapshot struct {
+	mu       sync.Mutex
+	pages    map[uid]*Page
+	released bool // pages are dropped, reads would see the live tree
+}
+
+// addSnapshot registers a new snapshot,
+// no update may be in progress
+func (mgr *BufMgr) addSnapshot() *snapshot {
+	snap := &snapshot{pages: make(map[uid]*Page)}
+
+	mgr.snapLock.Lock()
+	defer mgr.snapLock.Unlock()
+
+	if mgr.snapshots == nil {
+		mgr.snapshots = make(map[*snapshot]struct{})
+	}
+	mgr.snapshots[snap] = struct{}{}
+	atomic.AddInt32(&mgr.snapCount, 1)
+
+	return snap
+}
+
+func (mgr *BufMgr) removeSnapshot(snap *snapshot) {
+	mgr.snapLock.Lock()
+	defer mgr.snapLock.Unlock()
+
+	if _, ok := mgr.snapshots[snap]; ok {
+		delete(mgr.snapshots, snap)
+		atomic.AddInt32(&mgr.snapCount, -1)
+	}
+
+	snap.mu.Lock()
+	snap.pages = nil
+	snap.released = true
+	snap.mu.Unlock()
+}
+
+// isReleased reports whether the snapshot was released. Readers check it
+// after reading, a release during the read may have mixed in live pages.
+func (snap *snapshot) isReleased() bool {
+	if snap == nil {
+		return false
+	}
+
+	snap.mu.Lock()
+	defer snap.mu.Unlock()
+
+	return snap.released
+}
+
+// preserve copies the write locked page into the open snapshots
+// that do not hold an image of it yet
+func (mgr *BufMgr) preserve(latch *LatchSet) {
+	if atomic.LoadInt32(&mgr.snapCount) == 0 {
+		return
+	}
+
+	mgr.snapLock.Lock()
+	defer mgr.snapLock.Unlock()
+
+	page := &mgr.pagePool[latch.entry]
+	for snap := range mgr.snapshots {
+		snap.mu.Lock()
+		if _, ok := snap.pages[latch.pageNo]; !ok {
+			image := &Page{PageHeader: page.PageHeader, Data: make([]byte, len(page.Data))}
+			copy(image.Data, page.Data)
+			snap.pages[latch.pageNo] = image
+		}
+		snap.mu.Unlock()
+	}
+}
+
+// view returns the image of the locked page as of the snapshot
+func (snap *snapshot) view(latch *LatchSet, page *Page) *Page {
+	if snap == nil {
+		return page
+	}
+
+	snap.mu.Lock()
+	defer snap.mu.Unlock()
+
+	if image, ok := snap.pages[latch.pageNo]; ok {
+		return image
+	}
+	return page
+}
+
+// Snapshot is a read-only view of a Tree as of the time it was taken.
+// Updates made to the tree afterwards are not visible through it.
+// Reads fail with ErrSnapshotReleased once it is released, including
+// the reads of the iterators opened on it.
+type Snapshot struct {
+	t    *Tree
+	snap *snapshot
+}
+
+// Snapshot returns a consistent view of the tree, waiting for the
+// updates in progress to finish. Release it when done, the pages
+// modified while it is open are kept in memory.
+func (t *Tree) Snapshot() *Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return &Snapshot{t: t, snap: t.mgr.addSnapshot()}
+}
+
+// handle borrows an access handle reading through the snapshot
+func (s *Snapshot) handle() *BLTree {
+	tree := s.t.handle()
+	tree.snap = s.snap
+	return tree
+}
+
+// Get returns the value stored for key in the snapshot, or ErrNotFound
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if s.snap.isReleased() {
+		return nil, ErrSnapshotReleased
+	}
+
+	tree := s.handle()
+	defer s.t.release(tree)
+
+	value, err := s.t.get(tree, key)
+	if s.snap.isReleased() {
+		return nil, ErrSnapshotReleased
+	}
+	return value, err
+}
+
+// NewIterator returns an unpositioned iterator over the keys
+// of the snapshot in opts
+func (s *Snapshot) NewIterator(opts IterOptions) *Iterator {
+	if s.snap.isReleased() {
+		return &Iterator{err: ErrSnapshotReleased}
+	}
+	return &Iterator{t: s.t, tree: s.handle(), opts: opts}
+}
+
+// NewPrefixIterator returns an unpositioned iterator over the keys
+// of the snapshot starting with prefix
+func (s *Snapshot) NewPrefixIterator(prefix []byte) *Iterator {
+	return s.NewIterator(IterOptions{Start: prefix, End: prefixEnd(prefix)})
+}
+
+// Scan calls fn for each key of the snapshot in [start, end)
+// in ascending order until fn returns false
+func (s *Snapshot) Scan(start, end []byte, fn func(key, value []byte) bool) error {
+	return scan(s.NewIterator(IterOptions{Start: start, End: end}), fn)
+}
+
+// Release frees the pages kept for the snapshot
+func (s *Snapshot) Release() {
+	s.t.mgr.removeSnapshot(s.snap)
+}
@@ -0,0 +1,238 @@
+package blinktree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	_ = os.Remove("data/snapshot.db")
+	tree, err := Open("data/snapshot.db", Options{PoolSize: 32, Durability: DurabilityNone})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	keyOf := func(i int) []byte {
+		return []byte(fmt.Sprintf("key%05d", i))
+	}
+	large := bytes.Repeat([]byte("old"), int(tree.mgr.pageDataSize))
+
+	num := 2000
+	for i := 0; i < num; i++ {
+		if err := tree.Put(keyOf(i), []byte("v1")); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+	if err := tree.Put([]byte("large"), large); err != nil {
+		t.Fatalf("Put() err = %v", err)
+	}
+
+	snap := tree.Snapshot()
+
+	// grow values to split pages, delete and add keys,
+	// and replace the overflow value so its pages are reused
+	for i := 0; i < num; i++ {
+		if i%4 == 0 {
+			if err := tree.Delete(keyOf(i)); err != nil {
+				t.Fatalf("Delete() err = %v", err)
+			}
+		} else if err := tree.Put(keyOf(i), bytes.Repeat([]byte("v2"), 20)); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+		if err := tree.Put(keyOf(num+i), []byte("new")); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+	if err := tree.Delete([]byte("large")); err != nil {
+		t.Fatalf("Delete() err = %v", err)
+	}
+	if err := tree.Put([]byte("larger"), bytes.Repeat([]byte("new"), int(tree.mgr.pageDataSize))); err != nil {
+		t.Fatalf("Put() err = %v", err)
+	}
+
+	for i := 0; i < 2*num; i++ {
+		got, err := snap.Get(keyOf(i))
+		if i < num {
+			if err != nil || string(got) != "v1" {
+				t.Fatalf("Snapshot.Get(%q) = %q, %v, want %q", keyOf(i), got, err, "v1")
+			}
+		} else if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Snapshot.Get(%q) = %q, %v, want %v", keyOf(i), got, err, ErrNotFound)
+		}
+	}
+	if got, err := snap.Get([]byte("large")); err != nil || !bytes.Equal(got, large) {
+		t.Errorf("Snapshot.Get(large) = %d bytes, %v, want %d bytes", len(got), err, len(large))
+	}
+	if got, err := tree.Get([]byte("large")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(large) = %d bytes, %v, want %v", len(got), err, ErrNotFound)
+	}
+
+	t.Run("iterate", func(t *testing.T) {
+		i := 0
+		err := snap.Scan(keyOf(0), keyOf(2*num), func(key, value []byte) bool {
+			if !bytes.Equal(key, keyOf(i)) || string(value) != "v1" {
+				t.Fatalf("Scan() = %q, %q, want %q, %q", key, value, keyOf(i), "v1")
+			}
+			i++
+			return true
+		})
+		if err != nil || i != num {
+			t.Errorf("Scan() visited %d keys, %v, want %d", i, err, num)
+		}
+
+		it := snap.NewIterator(IterOptions{})
+		defer it.Close()
+		n := 0
+		for it.SeekLast(); it.Valid(); it.Prev() {
+			n++
+		}
+		if n != num+1 {
+			t.Errorf("reverse iteration visited %d keys, want %d", n, num+1)
+		}
+	})
+
+	snap.Release()
+	if _, err := snap.Get(keyOf(1)); !errors.Is(err, ErrSnapshotReleased) {
+		t.Errorf("Get() after Release() err = %v, want %v", err, ErrSnapshotReleased)
+	}
+	if len(tree.mgr.snapshots) != 0 {
+		t.Errorf("%d snapshots open after Release()", len(tree.mgr.snapshots))
+	}
+}
+
+func TestSnapshot_consistentScan(t *testing.T) {
+	_ = os.Remove("data/snapshot_scan.db")
+	tree, err := Open("data/snapshot_scan.db", Options{PoolSize: 64, Durability: DurabilityNone})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	keys := 500
+	writeGeneration := func(gen uint64) error {
+		var b Batch
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, gen)
+		for i := 0; i < keys; i++ {
+			b.Put([]byte(fmt.Sprintf("key%05d", i)), value)
+		}
+		return tree.Write(&b)
+	}
+	if err := writeGeneration(0); err != nil {
+		t.Fatalf("Write() err = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for gen := uint64(1); ; gen++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := writeGeneration(gen); err != nil {
+				t.Errorf("Write() err = %v", err)
+				return
+			}
+		}
+	}()
+
+	for round := 0; round < 20; round++ {
+		snap := tree.Snapshot()
+		var first []byte
+		n := 0
+		err := snap.Scan(nil, nil, func(key, value []byte) bool {
+			if first == nil {
+				first = value
+			} else if !bytes.Equal(value, first) {
+				t.Errorf("round %d: %q = %v, first key = %v", round, key, value, first)
+				return false
+			}
+			n++
+			return true
+		})
+		snap.Release()
+		if err != nil || n != keys {
+			t.Errorf("round %d: Scan() visited %d keys, %v, want %d", round, n, err, keys)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestSnapshot_release(t *testing.T) {
+	tree, err := Open("", Options{InMemory: true, PageBits: 12})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	keyOf := func(i int) []byte {
+		return []byte(fmt.Sprintf("key%05d", i))
+	}
+	num := 1000
+	for i := 0; i < num; i++ {
+		if err := tree.Put(keyOf(i), []byte("v1")); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+
+	snap := tree.Snapshot()
+	it := snap.NewIterator(IterOptions{})
+	defer it.Close()
+	if it.Seek(nil); !it.Valid() || string(it.Value()) != "v1" {
+		t.Fatalf("Seek() = %q, %v, want %q", it.Value(), it.Err(), "v1")
+	}
+
+	// readers racing the release see the snapshot or fail
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := r; ; i = (i + 4) % num {
+				got, err := snap.Get(keyOf(i))
+				if errors.Is(err, ErrSnapshotReleased) {
+					return
+				}
+				if err != nil || string(got) != "v1" {
+					t.Errorf("Snapshot.Get(%q) = %q, %v, want %q", keyOf(i), got, err, "v1")
+					return
+				}
+			}
+		}(r)
+	}
+	for i := 0; i < num; i++ {
+		if err := tree.Put(keyOf(i), []byte("v2")); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+	snap.Release()
+	wg.Wait()
+	snap.Release()
+
+	if it.Next(); it.Valid() || !errors.Is(it.Err(), ErrSnapshotReleased) {
+		t.Errorf("Next() after Release() = %q, %v, want %v", it.Value(), it.Err(), ErrSnapshotReleased)
+	}
+	if it.Seek(keyOf(10)); it.Valid() || !errors.Is(it.Err(), ErrSnapshotReleased) {
+		t.Errorf("Seek() after Release() = %q, %v, want %v", it.Value(), it.Err(), ErrSnapshotReleased)
+	}
+	if it.SeekLast(); it.Valid() || !errors.Is(it.Err(), ErrSnapshotReleased) {
+		t.Errorf("SeekLast() after Release() = %q, %v, want %v", it.Value(), it.Err(), ErrSnapshotReleased)
+	}
+	if err := snap.Scan(nil, nil, func(key, value []byte) bool { return true }); !errors.Is(err, ErrSnapshotReleased) {
+		t.Errorf("Scan() after Release() err = %v, want %v", err, ErrSnapshotReleased)
+	}
+	if _, err := snap.Get(keyOf(1)); !errors.Is(err, ErrSnapshotReleased) {
+		t.Errorf("Get() after Release() err = %v, want %v", err, ErrSnapshotReleased)
+	}
+}
@@ -0,0 +1,160 @@
+package blinktree
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats reports the shape of a tree and the activity of its buffer pool
+type Stats struct {
+	Height       int      // number of levels, leaves included
+	LevelPages   []uint64 // number of pages of each level, leaves first
+	Pages        uint64   // pages allocated in the file, page zero excluded
+	FreePages    uint64   // length of the free chain
+	FillFactor   float64  // share of the page data area used by live keys, averaged over the tree pages
+	GarbageBytes uint64   // bytes of deleted keys and values not reclaimed yet
+
+	PoolSize      uint   // pages the buffer pool can hold
+	PoolHits      uint64 // page pins finding the page in the pool
+	PoolMisses    uint64 // page pins reading the page from the log or the tree file
+	PoolEvictions uint64 // pool entries taken over by another page
+	DirtyPages    uint64 // pool pages updated and not logged yet
+	PageReads     uint64 // pages read from the log or the tree file
+	PageWrites    uint64 // pages appended to the log
+	Checkpoints   uint64 // checkpoints copying the log into the tree file
+	LatchWaits    uint64 // page locks that were not granted at once
+	LogFrames     uint64 // pages appended to the log since the last checkpoint
+}
+
+// Stats walks every level of the tree and reports its shape together
+// with the buffer pool counters accumulated since Open. Every operation
+// on the tree waits for the walk to finish, so the cost grows with the
+// size of the tree.
+func (t *Tree) Stats() (*Stats, error) {
+	t.mu.Lock()
+	s, err := t.walkStats()
+	t.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	t.lastStats.store(s)
+	return s, nil
+}
+
+// walkStats computes the Stats of the tree, the caller holds t.mu
+func (t *Tree) walkStats() (*Stats, error) {
+	mgr := t.mgr
+	s := mgr.counters()
+	for slot := uint32(1); slot <= mgr.latchDeployed; slot++ {
+		if mgr.latchSets[slot].dirty {
+			s.DirtyPages++
+		}
+	}
+
+	// levels are walked from the root, LevelPages is filled leaves first
+	var levelPages []uint64
+	var used, pages uint64
+	lvl := -1
+	err := t.walkLevels(func(pageNo uid, page *Page) error {
+		if int(page.Lvl) != lvl {
+			lvl = int(page.Lvl)
+			levelPages = append(levelPages, 0)
+		}
+		levelPages[len(levelPages)-1]++
+		pages++
+		s.GarbageBytes += uint64(page.Garbage)
+
+		for slot := uint32(1); slot <= page.Cnt; slot++ {
+			if !page.Dead(slot) {
+				off := page.KeyOffset(slot)
+				used += SlotSize + 2 + uint64(page.Data[off]) + uint64(page.Data[page.ValueOffset(slot)])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.Height = len(levelPages)
+	for i := len(levelPages) - 1; i >= 0; i-- {
+		s.LevelPages = append(s.LevelPages, levelPages[i])
+	}
+	if pages > 0 {
+		s.FillFactor = float64(used) / float64(pages*uint64(mgr.pageDataSize))
+	}
+
+	// stop at a page not marked free, the chain would lead into the tree
+	for pageNo := GetID(mgr.pageZero.Chain()); pageNo > 0 && s.FreePages < s.Pages; s.FreePages++ {
+		page, err := mgr.readPageCopy(pageNo)
+		if err != nil {
+			return nil, err
+		}
+		if !page.Free {
+			break
+		}
+		pageNo = GetID(&page.Right)
+	}
+	return s, nil
+}
+
+// counters returns the Stats kept up to date by the buffer manager,
+// which are read without waiting for the operations in progress
+func (mgr *BufMgr) counters() *Stats {
+	return &Stats{
+		Pages:         uint64(mgr.allocLimit()) - 1,
+		PoolSize:      mgr.latchTotal,
+		PoolHits:      mgr.stats.hits.Load(),
+		PoolMisses:    mgr.stats.misses.Load(),
+		PoolEvictions: mgr.stats.evictions.Load(),
+		PageReads:     mgr.stats.reads.Load(),
+		PageWrites:    mgr.stats.writes.Load(),
+		Checkpoints:   mgr.stats.checkpoints.Load(),
+		LatchWaits:    mgr.stats.latchWaits.Load(),
+		LogFrames:     uint64(mgr.wal.frames()),
+	}
+}
+
+// statsMaxAge is how old the Stats served by MetricsHandler
+// may get before the tree is walked again
+const statsMaxAge = time.Minute
+
+// statsCache keeps the last Stats walked from the tree
+type statsCache struct {
+	mu      sync.Mutex
+	last    Stats
+	at      time.Time // zero until the first walk
+	walking bool
+}
+
+func (c *statsCache) store(s *Stats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.last = *s
+	c.last.LevelPages = append([]uint64(nil), s.LevelPages...)
+	c.at = time.Now()
+}
+
+// cachedStats returns the last Stats walked from the tree and when they
+// were walked, the zero time before any walk. Once they are older than
+// statsMaxAge the tree is walked again in the background, so the caller
+// does not wait for the operations on the tree.
+func (t *Tree) cachedStats() (Stats, time.Time) {
+	c := &t.lastStats
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.at) >= statsMaxAge && !c.walking {
+		c.walking = true
+		go func() {
+			_, _ = t.Stats()
+
+			c.mu.Lock()
+			c.walking = false
+			c.mu.Unlock()
+		}()
+	}
+	return c.last, c.at
+}
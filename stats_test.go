@@ -0,0 +1,86 @@
+package blinktree
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTree_Stats(t *testing.T) {
+	tree := newCheckTree(t)
+	defer tree.Close()
+
+	// free some pages, committing each delete to reach a checkpoint:
+	// an in-memory tree only commits on Sync
+	for i := 0; i < 1000; i++ {
+		if err := tree.Delete([]byte(fmt.Sprintf("key%05d", i))); err != nil {
+			t.Fatalf("Delete() err = %v", err)
+		}
+		if err := tree.Sync(); err != nil {
+			t.Fatalf("Sync() err = %v", err)
+		}
+	}
+
+	s, err := tree.Stats()
+	if err != nil {
+		t.Fatalf("Stats() err = %v", err)
+	}
+	report, err := tree.Check()
+	if err != nil {
+		t.Fatalf("Check() err = %v", err)
+	}
+
+	if s.Height != report.Levels || len(s.LevelPages) != s.Height {
+		t.Errorf("Stats() Height = %d, LevelPages = %v, want %d levels", s.Height, s.LevelPages, report.Levels)
+	}
+	var pages uint64
+	for lvl, n := range s.LevelPages {
+		if lvl > 0 && n > s.LevelPages[lvl-1] {
+			t.Errorf("Stats() LevelPages = %v, want fewer pages on upper levels", s.LevelPages)
+		}
+		pages += n
+	}
+	if pages != report.Pages {
+		t.Errorf("Stats() has %d tree pages, want %d", pages, report.Pages)
+	}
+	if want := report.Pages + report.OverflowPages + report.FreePages; s.Pages != want || s.FreePages != report.FreePages || s.FreePages == 0 {
+		t.Errorf("Stats() Pages = %d, FreePages = %d, want %d, %d", s.Pages, s.FreePages, want, report.FreePages)
+	}
+	if s.FillFactor <= 0 || s.FillFactor > 1 {
+		t.Errorf("Stats() FillFactor = %v, want a fraction", s.FillFactor)
+	}
+	if s.GarbageBytes == 0 {
+		t.Errorf("Stats() GarbageBytes = 0 after deletes")
+	}
+
+	// the pool holds 64 pages, fewer than the tree
+	if s.PoolSize != 64 || s.PoolHits == 0 || s.PoolMisses == 0 || s.PoolEvictions == 0 {
+		t.Errorf("Stats() PoolSize = %d, PoolHits = %d, PoolMisses = %d, PoolEvictions = %d, want pool activity",
+			s.PoolSize, s.PoolHits, s.PoolMisses, s.PoolEvictions)
+	}
+	if s.PageReads < s.PoolMisses || s.PageWrites == 0 || s.Checkpoints == 0 {
+		t.Errorf("Stats() PageReads = %d, PageWrites = %d, Checkpoints = %d, want page I/O",
+			s.PageReads, s.PageWrites, s.Checkpoints)
+	}
+}
+
+func TestTree_Stats_dirtyPages(t *testing.T) {
+	tree, err := Open("", Options{InMemory: true, PoolSize: 16})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	if err := tree.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("Put() err = %v", err)
+	}
+	if s, err := tree.Stats(); err != nil || s.DirtyPages != 1 {
+		t.Errorf("Stats() DirtyPages = %d, %v, want 1", s.DirtyPages, err)
+	}
+
+	if err := tree.Sync(); err != nil {
+		t.Fatalf("Sync() err = %v", err)
+	}
+	if s, err := tree.Stats(); err != nil || s.DirtyPages != 0 {
+		t.Errorf("Stats() after Sync() DirtyPages = %d, %v, want 0", s.DirtyPages, err)
+	}
+}
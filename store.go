@@ -0,0 +1,123 @@
+package blinktree
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// PageStore is the storage of a btree file or of its write-ahead log.
+// The store is addressed by page number, page pageNo of a buffer of n
+// bytes starting at byte offset pageNo*n. A PageStore must be safe
+// for concurrent use.
+type PageStore interface {
+	// ReadPage fills buf with page pageNo. Reading past the end of
+	// the store returns an error wrapping io.EOF.
+	ReadPage(pageNo uint64, buf []byte) error
+	// WritePage stores buf as page pageNo, extending the store as needed.
+	WritePage(pageNo uint64, buf []byte) error
+	// Sync makes the written pages durable.
+	Sync() error
+	// Size returns the size of the store in bytes.
+	Size() (int64, error)
+	// Close releases the store.
+	Close() error
+}
+
+// fileStore is a PageStore on an operating system file
+type fileStore struct {
+	f *os.File
+}
+
+// NewFileStore returns a PageStore reading and writing f
+func NewFileStore(f *os.File) PageStore {
+	return &fileStore{f: f}
+}
+
+func (s *fileStore) ReadPage(pageNo uint64, buf []byte) error {
+	_, err := s.f.ReadAt(buf, int64(pageNo)*int64(len(buf)))
+	return err
+}
+
+func (s *fileStore) WritePage(pageNo uint64, buf []byte) error {
+	_, err := s.f.WriteAt(buf, int64(pageNo)*int64(len(buf)))
+	return err
+}
+
+func (s *fileStore) Sync() error {
+	return s.f.Sync()
+}
+
+func (s *fileStore) Size() (int64, error) {
+	info, err := s.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (s *fileStore) Close() error {
+	return s.f.Close()
+}
+
+// memStore is a PageStore kept in memory
+type memStore struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+// NewMemStore returns an empty PageStore kept in memory
+func NewMemStore() PageStore {
+	return &memStore{}
+}
+
+func (s *memStore) ReadPage(pageNo uint64, buf []byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	off := int64(pageNo) * int64(len(buf))
+	if off >= int64(len(s.data)) {
+		return io.EOF
+	}
+	if n := copy(buf, s.data[off:]); n < len(buf) {
+		return io.EOF
+	}
+	return nil
+}
+
+func (s *memStore) WritePage(pageNo uint64, buf []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	off := int64(pageNo) * int64(len(buf))
+	if end := off + int64(len(buf)); end > int64(len(s.data)) {
+		// the store never shrinks, the spare capacity is still zeroed
+		if end > int64(cap(s.data)) {
+			data := make([]byte, len(s.data), 2*end)
+			copy(data, s.data)
+			s.data = data
+		}
+		s.data = s.data[:end]
+	}
+	copy(s.data[off:], buf)
+	return nil
+}
+
+func (s *memStore) Sync() error {
+	return nil
+}
+
+func (s *memStore) Size() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return int64(len(s.data)), nil
+}
+
+func (s *memStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data = nil
+	return nil
+}
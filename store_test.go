@@ -0,0 +1,116 @@
+package blinktree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestPageStore(t *testing.T) {
+	_ = os.Remove("data/page_store.db")
+	f, err := os.OpenFile("data/page_store.db", os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile() err = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		store PageStore
+	}{
+		{name: "file", store: NewFileStore(f)},
+		{name: "memory", store: NewMemStore()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer tt.store.Close()
+
+			for _, pageNo := range []uint64{2, 0} {
+				if err := tt.store.WritePage(pageNo, bytes.Repeat([]byte{byte(pageNo + 1)}, 16)); err != nil {
+					t.Fatalf("WritePage(%d) err = %v", pageNo, err)
+				}
+			}
+			if size, err := tt.store.Size(); err != nil || size != 48 {
+				t.Errorf("Size() = %d, %v, want 48", size, err)
+			}
+			if err := tt.store.Sync(); err != nil {
+				t.Errorf("Sync() err = %v", err)
+			}
+
+			buf := make([]byte, 16)
+			for pageNo, want := range []byte{1, 0, 3} {
+				if err := tt.store.ReadPage(uint64(pageNo), buf); err != nil || !bytes.Equal(buf, bytes.Repeat([]byte{want}, 16)) {
+					t.Errorf("ReadPage(%d) = %v, %v, want %d bytes of %d", pageNo, buf, err, len(buf), want)
+				}
+			}
+			// a larger page only partly stored
+			if err := tt.store.ReadPage(1, make([]byte, 32)); !errors.Is(err, io.EOF) {
+				t.Errorf("ReadPage() past end err = %v, want %v", err, io.EOF)
+			}
+			if err := tt.store.ReadPage(3, buf); !errors.Is(err, io.EOF) {
+				t.Errorf("ReadPage() past end err = %v, want %v", err, io.EOF)
+			}
+		})
+	}
+}
+
+// countingStore counts the pages written to a memory store,
+// closing it leaves the pages in place to reopen the tree
+type countingStore struct {
+	PageStore
+	writes, syncs int
+}
+
+func (s *countingStore) WritePage(pageNo uint64, buf []byte) error {
+	s.writes++
+	return s.PageStore.WritePage(pageNo, buf)
+}
+
+func (s *countingStore) Sync() error {
+	s.syncs++
+	return s.PageStore.Sync()
+}
+
+func (s *countingStore) Close() error {
+	return nil
+}
+
+func TestOpenStore(t *testing.T) {
+	store := &countingStore{PageStore: NewMemStore()}
+	log := &countingStore{PageStore: NewMemStore()}
+
+	tree, err := OpenStore(store, log, Options{PageBits: BtMinBits, PoolSize: 16})
+	if err != nil {
+		t.Fatalf("OpenStore() err = %v", err)
+	}
+	num := 1000
+	for i := 0; i < num; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		if err := tree.Put(key, key); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+	if log.writes == 0 || log.syncs == 0 {
+		t.Errorf("write-ahead log store writes = %d, syncs = %d, want > 0", log.writes, log.syncs)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close() err = %v", err)
+	}
+	if store.writes == 0 || store.syncs == 0 {
+		t.Errorf("btree store writes = %d, syncs = %d, want > 0", store.writes, store.syncs)
+	}
+
+	tree, err = OpenStore(store, nil, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("OpenStore() read-only err = %v", err)
+	}
+	defer tree.Close()
+	for i := 0; i < num; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		if got, err := tree.Get(key); err != nil || !bytes.Equal(got, key) {
+			t.Fatalf("Get(%q) = %q, %v, want %q", key, got, err, key)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package blinktree
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+const (
+	DefaultPageBits     = 13      // default page size in bits for new files
+	DefaultPoolSize     = 1024    // default number of buffer pool pages
+	DefaultMaxValueSize = 1 << 20 // default largest value accepted by Put
+)
+
+// Durability selects when updates are committed to the write-ahead log
+type Durability uint8
+
+const (
+	// DurabilityFsync commits and fsyncs the log before each update
+	// returns, updates completed together share one commit
+	DurabilityFsync Durability = iota
+	// DurabilityFlush commits each update to the log without fsync,
+	// updates survive a process crash but not a system crash
+	DurabilityFlush
+	// DurabilityNone commits only on Sync and Close, a crash loses
+	// the updates made since the last Sync
+	DurabilityNone
+)
+
+// Options configures Open
+type Options struct {
+	// PageBits is the page size in bits used when a new file is created,
+	// from BtMinBits to BtMaxBits, or 0 for DefaultPageBits. An existing
+	// file keeps the page size it was created with.
+	PageBits uint8
+	// PoolSize is the number of pages kept in the buffer pool.
+	PoolSize uint
+	// MaxValueSize is the largest value Put accepts. Values that do not
+	// fit on a leaf page are stored on a chain of overflow pages.
+	MaxValueSize int
+	// Durability selects when updates are committed, DurabilityFsync by default.
+	Durability Durability
+	// ReadOnly opens an existing file without write permission. Updates
+	// return ErrReadOnly, and the write-ahead log is read but never
+	// checkpointed or created.
+	ReadOnly bool
+	// InMemory keeps the tree in memory without any disk I/O. The path
+	// passed to Open is ignored and the tree is discarded by Close.
+	// Durability is forced to DurabilityNone: updates are not committed
+	// one by one, the log in memory only holds the pages evicted from the
+	// buffer pool and lets batches and bulk loads roll back.
+	InMemory bool
+}
+
+// Tree is a B-link tree stored in a single file and its write-ahead log.
+// It is safe for concurrent use by multiple goroutines.
+type Tree struct {
+	mgr     *BufMgr
+	opts    Options
+	handles sync.Pool    // pool of *BLTree access handles
+	mu      sync.RWMutex // held shared by operations, exclusive by commits
+
+	group     groupCommit // commits shared by concurrent updates
+	lastStats statsCache  // last Stats, served by MetricsHandler
+}
+
+// Open opens the tree file at path, creating it if it does not exist.
+// Updates are logged to path with a "-wal" suffix, committed updates
+// found there after a crash are recovered.
+//
+// The file is locked until Close: a tree opened read-write excludes any
+// other open, read-only trees only exclude writers. Open fails with
+// ErrLocked instead of waiting for the lock.
+func Open(path string, opts Options) (*Tree, error) {
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	var mgr *BufMgr
+	var err error
+	switch {
+	case opts.InMemory && opts.ReadOnly:
+		return nil, fmt.Errorf("%w: an in-memory tree cannot be opened read-only", ErrReadOnly)
+	case opts.InMemory:
+		// there is nothing to keep across a crash
+		opts.Durability = DurabilityNone
+		mgr, err = NewMemBufMgr(opts.PageBits, opts.PoolSize)
+	case opts.ReadOnly:
+		mgr, err = NewBufMgr(path, BtRO, opts.PageBits, opts.PoolSize)
+	default:
+		mgr, err = NewBufMgr(path, BtRW, opts.PageBits, opts.PoolSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newTree(mgr, opts), nil
+}
+
+// OpenStore opens the tree kept in store, with its write-ahead log kept
+// in log, creating it if store is empty. A read-only tree accepts a nil
+// log. The stores are closed by Close; the InMemory option is ignored.
+func OpenStore(store, log PageStore, opts Options) (*Tree, error) {
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	mode := BtRW
+	if opts.ReadOnly {
+		mode = BtRO
+	}
+	mgr, err := NewStoreBufMgr(store, log, mode, opts.PageBits, opts.PoolSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTree(mgr, opts), nil
+}
+
+func (opts Options) withDefaults() Options {
+	if opts.PageBits == 0 {
+		opts.PageBits = DefaultPageBits
+	}
+	if opts.PoolSize == 0 {
+		opts.PoolSize = DefaultPoolSize
+	}
+	if opts.MaxValueSize <= 0 {
+		opts.MaxValueSize = DefaultMaxValueSize
+	} else if int64(opts.MaxValueSize) > math.MaxUint32 {
+		opts.MaxValueSize = math.MaxUint32
+	}
+	return opts
+}
+
+// validate rejects options the tree cannot work with
+func (opts Options) validate() error {
+	if opts.PageBits < BtMinBits || opts.PageBits > BtMaxBits {
+		return fmt.Errorf("%w: page bits %d not in [%d, %d]", ErrInvalidOption, opts.PageBits, BtMinBits, BtMaxBits)
+	}
+	return nil
+}
+
+func newTree(mgr *BufMgr, opts Options) *Tree {
+	t := &Tree{mgr: mgr, opts: opts}
+	t.group.cond.L = &t.group.mu
+	t.handles.New = func() any {
+		return NewBLTree(mgr)
+	}
+	return t
+}
+
+// handle borrows a BLTree access handle; return it with release
+func (t *Tree) handle() *BLTree {
+	return t.handles.Get().(*BLTree)
+}
+
+func (t *Tree) release(tree *BLTree) {
+	tree.snap = nil
+	t.handles.Put(tree)
+}
+
+// Get returns the value stored for key, or ErrNotFound
+func (t *Tree) Get(key []byte) ([]byte, error) {
+	tree := t.handle()
+	defer t.release(tree)
+
+	return t.get(tree, key)
+}
+
+func (t *Tree) get(tree *BLTree, key []byte) ([]byte, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	ret, _, value := tree.findKey(key, t.opts.MaxValueSize)
+	if tree.err != nil {
+		return nil, tree.err
+	}
+	if ret < 0 {
+		return nil, ErrNotFound
+	}
+
+	return value, nil
+}
+
+// writable returns ErrReadOnly when the tree was opened read-only
+func (t *Tree) writable() error {
+	if t.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// checkKey returns ErrKeyTooLarge for a key longer than max bytes and
+// ErrReservedKey for a key sorting at or after the stopper key
+func checkKey(key []byte, max int) error {
+	if len(key) > max {
+		return fmt.Errorf("%w: %d bytes", ErrKeyTooLarge, len(key))
+	}
+	if KeyCmp(key, stopperKey) >= 0 {
+		return fmt.Errorf("%w: %q", ErrReservedKey, key)
+	}
+	return nil
+}
+
+// Put stores value for key, replacing any existing value
+func (t *Tree) Put(key, value []byte) error {
+	if err := t.writable(); err != nil {
+		return err
+	}
+	if err := checkKey(key, MaxKey); err != nil {
+		return err
+	}
+	if len(value) > t.opts.MaxValueSize {
+		return fmt.Errorf("%w: %d bytes", ErrValueTooLarge, len(value))
+	}
+
+	tree := t.handle()
+	defer t.release(tree)
+
+	t.mu.RLock()
+	err := tree.insertKey(key, 0, value, true)
+	t.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	return t.commit()
+}
+
+// Delete removes key from the tree. Deleting a missing key is not an error.
+func (t *Tree) Delete(key []byte) error {
+	if err := t.writable(); err != nil {
+		return err
+	}
+
+	tree := t.handle()
+	defer t.release(tree)
+
+	t.mu.RLock()
+	err := tree.deleteKey(key, 0)
+	t.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	return t.commit()
+}
+
+// groupCommit lets the updates completed while a commit is in
+// progress share the next commit instead of taking one each
+type groupCommit struct {
+	mu      sync.Mutex
+	cond    sync.Cond
+	next    *commitRound // commit joined by the updates completed now
+	running bool
+}
+
+// commitRound is a commit shared by the updates
+// completed before it started
+type commitRound struct {
+	done bool
+	err  error
+}
+
+// commit writes the completed updates to the write-ahead log
+// as selected by the durability option. The caller joins the next
+// commit and either waits for it or, when no commit is running,
+// performs it on behalf of everyone who joined.
+func (t *Tree) commit() error {
+	if t.opts.Durability == DurabilityNone {
+		return nil
+	}
+
+	g := &t.group
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.next == nil {
+		g.next = &commitRound{}
+	}
+	round := g.next
+	for !round.done {
+		if g.running {
+			g.cond.Wait()
+			continue
+		}
+
+		// updates completed from now on join the following round
+		g.running = true
+		g.next = nil
+		g.mu.Unlock()
+
+		t.mu.Lock()
+		err := t.mgr.Commit(t.opts.Durability == DurabilityFsync)
+		t.mu.Unlock()
+
+		g.mu.Lock()
+		round.done, round.err = true, err
+		g.running = false
+		g.cond.Broadcast()
+	}
+	return round.err
+}
+
+// Sync commits all completed updates to the write-ahead log and fsyncs it,
+// waiting for the operations in progress to finish
+func (t *Tree) Sync() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.mgr.Commit(true)
+}
+
+// Close commits pending updates, writes them to the tree file
+// and closes the tree
+func (t *Tree) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.mgr.Close()
+}
@@ -0,0 +1,386 @@
+package blinktree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTree_PutGetDelete(t *testing.T) {
+	_ = os.Remove("data/tree_put_get_delete.db")
+	tree, err := Open("data/tree_put_get_delete.db", Options{})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	key := []byte{1, 2, 3, 4}
+	if _, err := tree.Get(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() err = %v, want %v", err, ErrNotFound)
+	}
+
+	if err := tree.Put(key, []byte{0, 0, 0, 0, 0, 1}); err != nil {
+		t.Errorf("Put() err = %v", err)
+	}
+	if got, err := tree.Get(key); err != nil || !bytes.Equal(got, []byte{0, 0, 0, 0, 0, 1}) {
+		t.Errorf("Get() = %v, %v, want %v", got, err, []byte{0, 0, 0, 0, 0, 1})
+	}
+
+	if err := tree.Delete(key); err != nil {
+		t.Errorf("Delete() err = %v", err)
+	}
+	if _, err := tree.Get(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Delete err = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestTree_reopen(t *testing.T) {
+	_ = os.Remove("data/tree_reopen.db")
+	tree, err := Open("data/tree_reopen.db", Options{PoolSize: 32})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+
+	num := uint64(10000)
+	for i := uint64(0); i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if err := tree.Put(bs, bs[2:]); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close() err = %v", err)
+	}
+
+	tree, err = Open("data/tree_reopen.db", Options{PoolSize: 32})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	for i := uint64(0); i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if got, err := tree.Get(bs); err != nil || !bytes.Equal(got, bs[2:]) {
+			t.Errorf("Get() = %v, %v, want %v", got, err, bs[2:])
+		}
+	}
+}
+
+func TestTree_errors(t *testing.T) {
+	if _, err := Open("data/no_such_dir/tree.db", Options{}); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open() err = %v, want %v", err, fs.ErrNotExist)
+	}
+
+	_ = os.Remove("data/tree_errors.db")
+	tree, err := Open("data/tree_errors.db", Options{})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	if err := tree.Put(make([]byte, MaxKey+1), nil); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("Put() err = %v, want %v", err, ErrKeyTooLarge)
+	}
+	for _, key := range [][]byte{{0xff, 0xff}, {0xff, 0xff, 0}} {
+		if err := tree.Put(key, nil); !errors.Is(err, ErrReservedKey) {
+			t.Errorf("Put(%x) err = %v, want %v", key, err, ErrReservedKey)
+		}
+	}
+	if err := tree.Put([]byte{0xff, 0xfe, 0xff}, nil); err != nil {
+		t.Errorf("Put() below the stopper key err = %v", err)
+	}
+
+	var page Page
+	if err := tree.mgr.readPage(&page, 100); !errors.Is(err, ErrRead) || !errors.Is(err, io.EOF) {
+		t.Errorf("readPage() err = %v, want %v wrapping %v", err, ErrRead, io.EOF)
+	}
+}
+
+func TestTree_pageBits(t *testing.T) {
+	for _, bits := range []uint8{1, BtMinBits - 1, BtMaxBits + 1, 24} {
+		if _, err := Open("", Options{InMemory: true, PageBits: bits}); !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("Open() with page bits %d err = %v, want %v", bits, err, ErrInvalidOption)
+		}
+		if _, err := OpenStore(NewMemStore(), NewMemStore(), Options{PageBits: bits}); !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("OpenStore() with page bits %d err = %v, want %v", bits, err, ErrInvalidOption)
+		}
+	}
+
+	// the extreme sizes fill and split their pages
+	for _, bits := range []uint8{BtMinBits, BtMaxBits} {
+		tree, err := Open("", Options{InMemory: true, PageBits: bits})
+		if err != nil {
+			t.Fatalf("Open() with page bits %d err = %v", bits, err)
+		}
+		value := make([]byte, 1<<bits/8)
+		for i := 0; i < 100; i++ {
+			if err := tree.Put([]byte(fmt.Sprintf("key%03d", i)), value); err != nil {
+				t.Fatalf("Put() with page bits %d err = %v", bits, err)
+			}
+		}
+		if report, err := tree.Check(); err != nil || len(report.Violations) > 0 || report.Levels < 2 {
+			t.Errorf("Check() with page bits %d = %+v, %v", bits, report, err)
+		}
+		tree.Close()
+	}
+}
+
+func TestTree_updateValue(t *testing.T) {
+	_ = os.Remove("data/tree_update_value.db")
+	tree, err := Open("data/tree_update_value.db", Options{PageBits: 12, PoolSize: 32})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	num := 2000
+	valueOf := func(i, round int) []byte {
+		return bytes.Repeat([]byte{byte(i)}, (i*7+round*31)%MaxValue)
+	}
+
+	for round := 0; round < 4; round++ {
+		for i := 0; i < num; i++ {
+			bs := make([]byte, 8)
+			binary.BigEndian.PutUint64(bs, uint64(i))
+			if err := tree.Put(bs, valueOf(i, round)); err != nil {
+				t.Fatalf("Put() err = %v", err)
+			}
+		}
+
+		for i := 0; i < num; i++ {
+			bs := make([]byte, 8)
+			binary.BigEndian.PutUint64(bs, uint64(i))
+			if got, err := tree.Get(bs); err != nil || !bytes.Equal(got, valueOf(i, round)) {
+				t.Fatalf("round %d Get() = %v, %v, want %v", round, got, err, valueOf(i, round))
+			}
+		}
+	}
+
+	// revive deleted keys with a larger value
+	for i := 0; i < num; i += 3 {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, uint64(i))
+		if err := tree.Delete(bs); err != nil {
+			t.Fatalf("Delete() err = %v", err)
+		}
+		if err := tree.Put(bs, bytes.Repeat([]byte{1}, MaxValue)); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+		if got, err := tree.Get(bs); err != nil || len(got) != MaxValue {
+			t.Fatalf("Get() = %v, %v, want %d bytes", got, err, MaxValue)
+		}
+	}
+
+	// the entries left behind by the updates are counted as garbage
+	report, err := tree.Check()
+	if err != nil {
+		t.Fatalf("Check() err = %v", err)
+	}
+	if err := report.Err(); err != nil {
+		t.Errorf("Check() violations %v", report.Violations)
+	}
+
+	if err := tree.Put([]byte{1}, make([]byte, DefaultMaxValueSize+1)); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("Put() err = %v, want %v", err, ErrValueTooLarge)
+	}
+}
+
+func TestTree_readOnly(t *testing.T) {
+	if _, err := Open("data/tree_read_only_missing.db", Options{ReadOnly: true}); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open() err = %v, want %v", err, fs.ErrNotExist)
+	}
+
+	removeTree("data/tree_read_only.db")
+	tree, err := Open("data/tree_read_only.db", Options{})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	for i := 0; i < 300; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		if err := tree.Put(key, key); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+	// leave the committed updates in the write-ahead log
+	if tree.mgr.wal.frames() == 0 {
+		t.Fatalf("write-ahead log is empty")
+	}
+	crashTree(t, tree)
+
+	idx, _ := os.ReadFile("data/tree_read_only.db")
+	log, _ := os.ReadFile("data/tree_read_only.db-wal")
+
+	tree, err = Open("data/tree_read_only.db", Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Open() read-only err = %v", err)
+	}
+	for i := 0; i < 300; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		if got, err := tree.Get(key); err != nil || !bytes.Equal(got, key) {
+			t.Fatalf("Get(%q) = %q, %v, want %q", key, got, err, key)
+		}
+	}
+
+	var b Batch
+	b.Put([]byte("a"), nil)
+	updates := map[string]error{
+		"Put":    tree.Put([]byte("a"), nil),
+		"Delete": tree.Delete([]byte("key00001")),
+		"Write":  tree.Write(&b),
+	}
+	_, updates["PutDup"] = tree.PutDup([]byte("a"), nil)
+	updates["DeleteDupID"] = tree.DeleteDupID([]byte("a"), 1)
+	for name, err := range updates {
+		if !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s() err = %v, want %v", name, err, ErrReadOnly)
+		}
+	}
+	if err := tree.Sync(); err != nil {
+		t.Errorf("Sync() err = %v", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close() err = %v", err)
+	}
+
+	if got, _ := os.ReadFile("data/tree_read_only.db"); !bytes.Equal(got, idx) {
+		t.Errorf("btree file modified by read-only tree")
+	}
+	if got, _ := os.ReadFile("data/tree_read_only.db-wal"); !bytes.Equal(got, log) {
+		t.Errorf("write-ahead log modified by read-only tree")
+	}
+}
+
+func TestTree_inMemory(t *testing.T) {
+	removeTree("data/tree_in_memory.db")
+	tree, err := Open("data/tree_in_memory.db", Options{InMemory: true, PageBits: BtMinBits, PoolSize: 16})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	// enough keys to evict pages from the small pool
+	num := 5000
+	for i := 0; i < num; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		if err := tree.Put(key, key); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+	for i := 0; i < num; i += 2 {
+		if err := tree.Delete([]byte(fmt.Sprintf("key%05d", i))); err != nil {
+			t.Fatalf("Delete() err = %v", err)
+		}
+	}
+
+	// updates are not committed one by one
+	if tree.opts.Durability != DurabilityNone || tree.mgr.stats.checkpoints.Load() > 0 {
+		t.Errorf("Durability = %d, %d checkpoints, want no commits", tree.opts.Durability, tree.mgr.stats.checkpoints.Load())
+	}
+
+	// a failed batch is rolled back in memory too
+	var b Batch
+	b.Put([]byte("key00000"), nil)
+	b.Put(bytes.Repeat([]byte{'x'}, 200), nil)
+	if err := tree.Write(&b); !errors.Is(err, ErrPageFull) {
+		t.Fatalf("Write() err = %v, want %v", err, ErrPageFull)
+	}
+
+	for i := 0; i < num; i++ {
+		key := []byte(fmt.Sprintf("key%05d", i))
+		got, err := tree.Get(key)
+		if i%2 == 0 {
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Get(%q) = %q, %v, want %v", key, got, err, ErrNotFound)
+			}
+		} else if err != nil || !bytes.Equal(got, key) {
+			t.Fatalf("Get(%q) = %q, %v, want %q", key, got, err, key)
+		}
+	}
+
+	for _, name := range []string{"data/tree_in_memory.db", "data/tree_in_memory.db-wal"} {
+		if _, err := os.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat(%q) err = %v, want %v", name, err, fs.ErrNotExist)
+		}
+	}
+}
+
+func TestTree_groupCommit(t *testing.T) {
+	_ = os.Remove("data/tree_group_commit.db")
+	tree, err := Open("data/tree_group_commit.db", Options{})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				if err := tree.Put([]byte(fmt.Sprintf("key%d-%04d", w, i)), []byte("value")); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Put() err = %v", err)
+	}
+
+	// every update returned after a commit covering it
+	mgr := tree.mgr
+	for slot := uint32(1); slot <= mgr.latchDeployed; slot++ {
+		if mgr.latchSets[slot].dirty {
+			t.Errorf("page %d not committed", mgr.latchSets[slot].pageNo)
+		}
+	}
+	if mgr.wal.pending() {
+		t.Errorf("log frames not committed")
+	}
+}
+
+func BenchmarkTree_Put(b *testing.B) {
+	for _, d := range []struct {
+		name       string
+		durability Durability
+	}{{"fsync", DurabilityFsync}, {"flush", DurabilityFlush}, {"none", DurabilityNone}} {
+		b.Run(d.name, func(b *testing.B) {
+			_ = os.Remove("data/bench_put.db")
+			_ = os.Remove("data/bench_put.db-wal")
+			tree, err := Open("data/bench_put.db", Options{Durability: d.durability})
+			if err != nil {
+				b.Fatalf("Open() err = %v", err)
+			}
+			defer tree.Close()
+
+			// writers finishing together share a commit
+			var seq atomic.Uint64
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				key := make([]byte, 8)
+				for pb.Next() {
+					binary.BigEndian.PutUint64(key, seq.Add(1))
+					if err := tree.Put(key, key); err != nil {
+						b.Errorf("Put() err = %v", err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
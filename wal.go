@@ -0,0 +1,287 @@
+package blinktree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"sync"
+	"time"
+)
+
+/*
+ *  Pages are never written to the btree file while the tree is in use.
+ *  Dirty pages evicted from the buffer pool and the pages flushed by a
+ *  commit are appended as frames to a write-ahead log kept next to the
+ *  btree file. A commit writes every remaining dirty page followed by
+ *  page zero in a frame flagged as the commit frame.
+ *
+ *  Commits are only taken while no update is in progress, so the frames
+ *  up to a commit frame always describe a consistent tree. Frames written
+ *  after the last commit belong to updates that have not committed yet;
+ *  they are rewritten in place when the same page is evicted again and
+ *  are discarded by recovery or by a rollback.
+ *
+ *  A checkpoint copies the latest committed frame of each page into the
+ *  btree file, syncs it and resets the log. On open, the committed frames
+ *  of an existing log are checkpointed before the tree is used.
+ *
+ *  The log is stored as a PageStore of frame sized records, the header
+ *  is record zero and the frames follow. Each frame carries the salt of
+ *  the log header and a crc32 checksum of its header and page, recovery
+ *  stops at the first frame that does not match. The store is never
+ *  truncated: a reset writes a new salt to the header, which invalidates
+ *  the frames left in place, and the frames discarded by a rollback are
+ *  never followed by a commit frame.
+ */
+
+const (
+	walMagic      = 0x4c41574b // log header magic number
+	walVersion    = 2          // log format version
+	walHeaderSize = 32         // size of log header in bytes
+	walFrameSize  = 24         // size of frame header in bytes
+
+	walCheckpointFrames = 1000 // number of frames that triggers a checkpoint on commit
+)
+
+// wal is the write-ahead log of a btree file
+type wal struct {
+	mu        sync.Mutex
+	store     PageStore
+	pageSize  uint32
+	salt      uint32
+	index     map[uid]uint64 // record of the latest committed frame of each page
+	uncommit  map[uid]uint64 // record of the frames written since the last commit
+	end       uint64         // record of the next frame
+	committed uint64         // record following the last commit frame
+	unsynced  bool           // frames were written since the last fsync
+	readOnly  bool           // the log is only read, a nil store is an empty log
+}
+
+// newWAL returns the log kept in store, recovering its committed frames.
+// A nil store is an empty log that is only read.
+func newWAL(store PageStore, pageSize uint32, readOnly bool) (*wal, error) {
+	w := &wal{
+		store:     store,
+		pageSize:  pageSize,
+		index:     make(map[uid]uint64),
+		uncommit:  make(map[uid]uint64),
+		end:       1,
+		committed: 1,
+		readOnly:  readOnly,
+	}
+	if store == nil {
+		return w, nil
+	}
+	if err := w.recover(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// recover reads the log header and indexes the frames up to the last commit
+func (w *wal) recover() error {
+	// a missing or torn header can only be left by a reset,
+	// the log holds no frames in that case. A new salt is
+	// drawn so that frames left from a previous log are ignored.
+	frame := make([]byte, walFrameSize+w.pageSize)
+	err := w.store.ReadPage(0, frame)
+	if err != nil || binary.LittleEndian.Uint32(frame[0:]) != walMagic ||
+		binary.LittleEndian.Uint32(frame[28:]) != crc32.ChecksumIEEE(frame[:28]) {
+		if w.readOnly {
+			return nil
+		}
+		w.salt = uint32(time.Now().UnixNano())
+		return w.reset()
+	}
+	if version := binary.LittleEndian.Uint32(frame[4:]); version != walVersion {
+		return fmt.Errorf("%w: write-ahead log version %d", ErrCorrupt, version)
+	}
+	if pageSize := binary.LittleEndian.Uint32(frame[8:]); pageSize != w.pageSize {
+		return fmt.Errorf("%w: write-ahead log page size %d, want %d", ErrCorrupt, pageSize, w.pageSize)
+	}
+	w.salt = binary.LittleEndian.Uint32(frame[12:])
+
+	frames := make(map[uid]uint64)
+	for rec := uint64(1); ; rec++ {
+		if err := w.store.ReadPage(rec, frame); err != nil {
+			break
+		}
+		pageNo, commit, ok := w.checkFrame(frame)
+		if !ok {
+			break
+		}
+
+		frames[pageNo] = rec
+		if commit {
+			for pageNo, rec := range frames {
+				w.index[pageNo] = rec
+			}
+			frames = make(map[uid]uint64)
+			w.committed = rec + 1
+		}
+	}
+	w.end = w.committed
+
+	return nil
+}
+
+// checkFrame validates a frame read from the log
+func (w *wal) checkFrame(frame []byte) (pageNo uid, commit bool, ok bool) {
+	if binary.LittleEndian.Uint32(frame[12:]) != w.salt {
+		return 0, false, false
+	}
+	if binary.LittleEndian.Uint32(frame[16:]) != w.checksum(frame) {
+		return 0, false, false
+	}
+
+	pageNo = uid(binary.LittleEndian.Uint64(frame[0:]))
+	commit = binary.LittleEndian.Uint32(frame[8:]) > 0
+	return pageNo, commit, true
+}
+
+func (w *wal) checksum(frame []byte) uint32 {
+	crc := crc32.ChecksumIEEE(frame[:16])
+	return crc32.Update(crc, crc32.IEEETable, frame[walFrameSize:])
+}
+
+// reset empties the log and starts a new generation of frames
+func (w *wal) reset() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.salt++
+	header := make([]byte, walFrameSize+w.pageSize)
+	binary.LittleEndian.PutUint32(header[0:], walMagic)
+	binary.LittleEndian.PutUint32(header[4:], walVersion)
+	binary.LittleEndian.PutUint32(header[8:], w.pageSize)
+	binary.LittleEndian.PutUint32(header[12:], w.salt)
+	binary.LittleEndian.PutUint32(header[28:], crc32.ChecksumIEEE(header[:28]))
+
+	if err := w.store.WritePage(0, header); err != nil {
+		return fmt.Errorf("%w: write-ahead log: %w", ErrWrite, err)
+	}
+	if err := w.store.Sync(); err != nil {
+		return fmt.Errorf("%w: write-ahead log: %w", ErrWrite, err)
+	}
+
+	w.index = make(map[uid]uint64)
+	w.uncommit = make(map[uid]uint64)
+	w.end = 1
+	w.committed = 1
+	w.unsynced = false
+
+	return nil
+}
+
+// append writes the encoded page as a frame of the log
+func (w *wal) append(pageNo uid, page []byte, commit bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	frame := make([]byte, walFrameSize+w.pageSize)
+	binary.LittleEndian.PutUint64(frame[0:], uint64(pageNo))
+	if commit {
+		binary.LittleEndian.PutUint32(frame[8:], 1)
+	}
+	binary.LittleEndian.PutUint32(frame[12:], w.salt)
+	copy(frame[walFrameSize:], page)
+	binary.LittleEndian.PutUint32(frame[16:], w.checksum(frame))
+
+	// an uncommitted frame of the page is rewritten in place
+	rec, ok := w.uncommit[pageNo]
+	if !ok || commit {
+		rec = w.end
+	}
+
+	if err := w.store.WritePage(rec, frame); err != nil {
+		return fmt.Errorf("%w %d: write-ahead log: %w", ErrWrite, pageNo, err)
+	}
+
+	w.uncommit[pageNo] = rec
+	w.unsynced = true
+	if rec == w.end {
+		w.end++
+	}
+	if commit {
+		for pageNo, rec := range w.uncommit {
+			w.index[pageNo] = rec
+		}
+		w.uncommit = make(map[uid]uint64)
+		w.committed = w.end
+	}
+
+	return nil
+}
+
+// rollback discards the frames written since the last commit
+func (w *wal) rollback() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.uncommit = make(map[uid]uint64)
+	w.end = w.committed
+}
+
+// read fills buf with the latest frame of pageNo,
+// returning false when the page is not in the log
+func (w *wal) read(pageNo uid, buf []byte) (bool, error) {
+	w.mu.Lock()
+	rec, ok := w.uncommit[pageNo]
+	if !ok {
+		rec, ok = w.index[pageNo]
+	}
+	w.mu.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+	frame := make([]byte, walFrameSize+w.pageSize)
+	if err := w.store.ReadPage(rec, frame); err != nil {
+		return true, fmt.Errorf("%w %d: write-ahead log: %w", ErrRead, pageNo, err)
+	}
+	copy(buf, frame[walFrameSize:])
+
+	return true, nil
+}
+
+// pending reports whether frames were written since the last commit
+func (w *wal) pending() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.uncommit) > 0
+}
+
+// frames returns the number of frames in the log
+func (w *wal) frames() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return int(w.end - 1)
+}
+
+// sync fsyncs the frames written since the last sync
+func (w *wal) sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.unsynced {
+		return nil
+	}
+	if err := w.store.Sync(); err != nil {
+		return fmt.Errorf("%w: write-ahead log: %w", ErrWrite, err)
+	}
+	w.unsynced = false
+	return nil
+}
+
+func (w *wal) close() error {
+	if w.store == nil {
+		return nil
+	}
+	if err := w.store.Close(); err != nil {
+		return fmt.Errorf("blinktree: unable to close write-ahead log: %w", err)
+	}
+	return nil
+}
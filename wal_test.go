@@ -0,0 +1,214 @@
+package blinktree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+)
+
+// crashTree closes the tree files without committing or checkpointing,
+// leaving them as a killed process would
+func crashTree(t *testing.T, tree *Tree) {
+	t.Helper()
+	if err := tree.mgr.wal.store.Close(); err != nil {
+		t.Fatalf("close write-ahead log err = %v", err)
+	}
+	if err := tree.mgr.idx.Close(); err != nil {
+		t.Fatalf("close btree file err = %v", err)
+	}
+}
+
+func removeTree(name string) {
+	_ = os.Remove(name)
+	_ = os.Remove(name + "-wal")
+}
+
+func TestTree_walRecovery(t *testing.T) {
+	removeTree("data/tree_wal_recovery.db")
+	tree, err := Open("data/tree_wal_recovery.db", Options{PoolSize: 32})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+
+	num := uint64(5000)
+	for i := uint64(0); i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if err := tree.Put(bs, bs[4:]); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+	for i := uint64(0); i < num; i += 2 {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if err := tree.Delete(bs); err != nil {
+			t.Fatalf("Delete() err = %v", err)
+		}
+	}
+	if tree.mgr.wal.frames() == 0 {
+		t.Fatalf("write-ahead log is empty before crash")
+	}
+	crashTree(t, tree)
+
+	tree, err = Open("data/tree_wal_recovery.db", Options{PoolSize: 32})
+	if err != nil {
+		t.Fatalf("Open() after crash err = %v", err)
+	}
+	defer tree.Close()
+
+	if got := tree.mgr.wal.frames(); got != 0 {
+		t.Errorf("frames() after recovery = %d, want 0", got)
+	}
+	for i := uint64(0); i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		got, err := tree.Get(bs)
+		if i%2 == 0 {
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get(%d) = %v, %v, want %v", i, got, err, ErrNotFound)
+			}
+		} else if err != nil || !bytes.Equal(got, bs[4:]) {
+			t.Errorf("Get(%d) = %v, %v, want %v", i, got, err, bs[4:])
+		}
+	}
+}
+
+func TestTree_walDiscardsUncommitted(t *testing.T) {
+	removeTree("data/tree_wal_uncommitted.db")
+	tree, err := Open("data/tree_wal_uncommitted.db", Options{PoolSize: 32})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+
+	num := uint64(1000)
+	for i := uint64(0); i < num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if err := tree.Put(bs, bs[4:]); err != nil {
+			t.Fatalf("Put() err = %v", err)
+		}
+	}
+
+	// update without commit and write the dirty pages to the log
+	// as evictions would, then append a torn frame
+	bltree := NewBLTree(tree.mgr)
+	for i := num; i < 2*num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		if err := bltree.insertKey(bs, 0, bs[4:], true); err != nil {
+			t.Fatalf("insertKey() err = %v", err)
+		}
+	}
+	for slot := uint32(1); slot <= tree.mgr.latchDeployed; slot++ {
+		if latch := &tree.mgr.latchSets[slot]; latch.dirty {
+			if err := tree.mgr.logPage(&tree.mgr.pagePool[slot], latch.pageNo); err != nil {
+				t.Fatalf("logPage() err = %v", err)
+			}
+		}
+	}
+	if !tree.mgr.wal.pending() {
+		t.Fatalf("no uncommitted frames in write-ahead log")
+	}
+	torn := bytes.Repeat([]byte{0xff}, walFrameSize+int(tree.mgr.pageSize))
+	if err := tree.mgr.wal.store.WritePage(tree.mgr.wal.end, torn); err != nil {
+		t.Fatalf("write torn frame err = %v", err)
+	}
+	crashTree(t, tree)
+
+	tree, err = Open("data/tree_wal_uncommitted.db", Options{PoolSize: 32})
+	if err != nil {
+		t.Fatalf("Open() after crash err = %v", err)
+	}
+	defer tree.Close()
+
+	for i := uint64(0); i < 2*num; i++ {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, i)
+		got, err := tree.Get(bs)
+		if i >= num {
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get(%d) = %v, %v, want %v", i, got, err, ErrNotFound)
+			}
+		} else if err != nil || !bytes.Equal(got, bs[4:]) {
+			t.Errorf("Get(%d) = %v, %v, want %v", i, got, err, bs[4:])
+		}
+	}
+}
+
+func TestTree_walStaleLog(t *testing.T) {
+	removeTree("data/tree_wal_stale.db")
+	tree, err := Open("data/tree_wal_stale.db", Options{})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	if err := tree.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("Put() err = %v", err)
+	}
+	crashTree(t, tree)
+
+	// a log left behind by a removed btree file is not replayed
+	_ = os.Remove("data/tree_wal_stale.db")
+	tree, err = Open("data/tree_wal_stale.db", Options{})
+	if err != nil {
+		t.Fatalf("Open() err = %v", err)
+	}
+	defer tree.Close()
+
+	if got, err := tree.Get([]byte("key")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() = %v, %v, want %v", got, err, ErrNotFound)
+	}
+}
+
+func TestTree_durability(t *testing.T) {
+	tests := []struct {
+		name       string
+		durability Durability
+		sync       bool
+		wantFound  bool
+	}{
+		{name: "fsync", durability: DurabilityFsync, wantFound: true},
+		{name: "flush", durability: DurabilityFlush, wantFound: true},
+		{name: "none", durability: DurabilityNone, wantFound: false},
+		{name: "none with sync", durability: DurabilityNone, sync: true, wantFound: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			removeTree("data/tree_durability.db")
+			tree, err := Open("data/tree_durability.db", Options{Durability: tt.durability})
+			if err != nil {
+				t.Fatalf("Open() err = %v", err)
+			}
+
+			num := uint64(100)
+			for i := uint64(0); i < num; i++ {
+				bs := make([]byte, 8)
+				binary.BigEndian.PutUint64(bs, i)
+				if err := tree.Put(bs, bs[4:]); err != nil {
+					t.Fatalf("Put() err = %v", err)
+				}
+			}
+			if tt.sync {
+				if err := tree.Sync(); err != nil {
+					t.Fatalf("Sync() err = %v", err)
+				}
+			}
+			crashTree(t, tree)
+
+			tree, err = Open("data/tree_durability.db", Options{Durability: tt.durability})
+			if err != nil {
+				t.Fatalf("Open() after crash err = %v", err)
+			}
+			defer tree.Close()
+
+			for i := uint64(0); i < num; i++ {
+				bs := make([]byte, 8)
+				binary.BigEndian.PutUint64(bs, i)
+				if _, err := tree.Get(bs); (err == nil) != tt.wantFound {
+					t.Errorf("Get(%d) err = %v, want found %v", i, err, tt.wantFound)
+				}
+			}
+		})
+	}
+}